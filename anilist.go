@@ -2,51 +2,135 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
-	"time"
+	"net/http"
 
 	"github.com/rl404/verniy"
 	"golang.org/x/oauth2"
 )
 
+const saveMediaListEntryMutation = `mutation (
+	$mediaId: Int,
+	$status: MediaListStatus,
+	$scoreRaw: Int,
+	$progress: Int,
+	$progressVolumes: Int,
+	$repeat: Int,
+	$notes: String,
+	$private: Boolean,
+	$startedAt: FuzzyDateInput,
+	$completedAt: FuzzyDateInput
+) {
+	SaveMediaListEntry(
+		mediaId: $mediaId,
+		status: $status,
+		scoreRaw: $scoreRaw,
+		progress: $progress,
+		progressVolumes: $progressVolumes,
+		repeat: $repeat,
+		notes: $notes,
+		private: $private,
+		startedAt: $startedAt,
+		completedAt: $completedAt
+	) {
+		id
+	}
+}`
+
+var anilistAnimeMediaFields = []verniy.MediaField{
+	verniy.MediaFieldID,
+	verniy.MediaFieldIDMAL,
+	verniy.MediaFieldTitle(
+		verniy.MediaTitleFieldRomaji,
+		verniy.MediaTitleFieldEnglish,
+		verniy.MediaTitleFieldNative,
+	),
+	verniy.MediaFieldFormat,
+	verniy.MediaFieldStatusV2,
+	verniy.MediaFieldEpisodes,
+	verniy.MediaFieldSeasonYear,
+}
+
+var anilistFavouriteMediaFields = []verniy.MediaConnectionField{
+	verniy.MediaConnectionFieldPageInfo(verniy.PageInfoFieldHasNextPage),
+	verniy.MediaConnectionFieldNodes(
+		verniy.MediaFieldID,
+		verniy.MediaFieldIDMAL,
+		verniy.MediaFieldTitle(
+			verniy.MediaTitleFieldRomaji,
+			verniy.MediaTitleFieldEnglish),
+	),
+}
+
+var anilistMangaMediaFields = []verniy.MediaField{
+	verniy.MediaFieldID,
+	verniy.MediaFieldIDMAL,
+	verniy.MediaFieldTitle(
+		verniy.MediaTitleFieldRomaji,
+		verniy.MediaTitleFieldEnglish,
+		verniy.MediaTitleFieldNative),
+	verniy.MediaFieldType,
+	verniy.MediaFieldFormat,
+	verniy.MediaFieldStatusV2,
+	verniy.MediaFieldChapters,
+	verniy.MediaFieldVolumes,
+}
+
+var anilistMediaListEntryFields = []verniy.MediaListField{
+	verniy.MediaListFieldID,
+	verniy.MediaListFieldStatus,
+	verniy.MediaListFieldScore,
+	verniy.MediaListFieldProgress,
+	verniy.MediaListFieldProgressVolumes,
+	verniy.MediaListFieldRepeat,
+	verniy.MediaListFieldNotes,
+	verniy.MediaListFieldPrivate,
+	verniy.MediaListFieldStartedAt,
+	verniy.MediaListFieldCompletedAt,
+	verniy.MediaListFieldUpdatedAt,
+}
+
 type AnilistClient struct {
 	c *verniy.Client
 
 	username string
 }
 
-func NewAnilistClient(ctx context.Context, oauth *OAuth, username string) (*AnilistClient, error) {
-	httpClient := oauth2.NewClient(ctx, oauth.TokenSource())
-	httpClient.Timeout = 10 * time.Minute
+func NewAnilistClient(ctx context.Context, oauth *OAuth, config SiteConfig, httpConfig HTTPConfig) (*AnilistClient, error) {
+	httpClient, err := newOAuthHTTPClient(ctx, oauth, httpConfig, "AniList", anilistRequestsPerMinute)
+	if err != nil {
+		return nil, err
+	}
 
 	v := verniy.New()
 	v.Http = *httpClient
+	if config.APIURL != "" {
+		v.Host = config.APIURL
+	}
 
-	return &AnilistClient{c: v, username: username}, nil
+	return &AnilistClient{c: v, username: config.Username}, nil
 }
 
 func (c *AnilistClient) GetUserAnimeList(ctx context.Context) ([]verniy.MediaListGroup, error) {
 	return c.c.GetUserAnimeListWithContext(ctx, c.username,
 		verniy.MediaListGroupFieldStatus,
+		verniy.MediaListGroupFieldName,
+		verniy.MediaListGroupFieldIsCustomList,
 		verniy.MediaListGroupFieldEntries(
 			verniy.MediaListFieldID,
 			verniy.MediaListFieldStatus,
 			verniy.MediaListFieldScore,
 			verniy.MediaListFieldProgress,
+			verniy.MediaListFieldRepeat,
+			verniy.MediaListFieldNotes,
+			verniy.MediaListFieldPrivate,
+			verniy.MediaListFieldPriority,
 			verniy.MediaListFieldStartedAt,
 			verniy.MediaListFieldCompletedAt,
-			verniy.MediaListFieldMedia(
-				verniy.MediaFieldID,
-				verniy.MediaFieldIDMAL,
-				verniy.MediaFieldTitle(
-					verniy.MediaTitleFieldRomaji,
-					verniy.MediaTitleFieldEnglish,
-					verniy.MediaTitleFieldNative,
-				),
-				verniy.MediaFieldStatusV2,
-				verniy.MediaFieldEpisodes,
-				verniy.MediaFieldSeasonYear,
-			),
+			verniy.MediaListFieldUpdatedAt,
+			verniy.MediaListFieldMedia(anilistAnimeMediaFields[0], anilistAnimeMediaFields[1:]...),
 		),
 	)
 
@@ -56,51 +140,410 @@ func (c *AnilistClient) GetUserMangaList(ctx context.Context) ([]verniy.MediaLis
 	return c.c.GetUserMangaListWithContext(ctx, c.username,
 		verniy.MediaListGroupFieldName,
 		verniy.MediaListGroupFieldStatus,
+		verniy.MediaListGroupFieldIsCustomList,
 		verniy.MediaListGroupFieldEntries(
 			verniy.MediaListFieldID,
 			verniy.MediaListFieldStatus,
 			verniy.MediaListFieldScore,
 			verniy.MediaListFieldProgress,
 			verniy.MediaListFieldProgressVolumes,
+			verniy.MediaListFieldRepeat,
+			verniy.MediaListFieldNotes,
+			verniy.MediaListFieldPrivate,
+			verniy.MediaListFieldPriority,
 			verniy.MediaListFieldStartedAt,
 			verniy.MediaListFieldCompletedAt,
-			verniy.MediaListFieldMedia(
-				verniy.MediaFieldID,
-				verniy.MediaFieldIDMAL,
-				verniy.MediaFieldTitle(
-					verniy.MediaTitleFieldRomaji,
-					verniy.MediaTitleFieldEnglish,
-					verniy.MediaTitleFieldNative),
-				verniy.MediaFieldType,
-				verniy.MediaFieldFormat,
-				verniy.MediaFieldStatusV2,
-				verniy.MediaFieldChapters,
-				verniy.MediaFieldVolumes,
-			),
+			verniy.MediaListFieldUpdatedAt,
+			verniy.MediaListFieldMedia(anilistMangaMediaFields[0], anilistMangaMediaFields[1:]...),
 		),
 	)
 }
 
+func (c *AnilistClient) GetScoreFormat(ctx context.Context) (verniy.ScoreFormat, error) {
+	user, err := c.c.GetUserWithContext(ctx, c.username,
+		verniy.UserFieldMediaListOptions(verniy.MediaListOptionsFieldScoreFormat),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if user.MediaListOptions == nil || user.MediaListOptions.ScoreFormat == nil {
+		return verniy.ScoreFormatPoint10, nil
+	}
+
+	return *user.MediaListOptions.ScoreFormat, nil
+}
+
+// GetUserFavourites returns the favourite anime and manga, and characters if requested.
+func (c *AnilistClient) GetUserFavourites(ctx context.Context, characters bool) ([]Favourite, error) {
+	var favourites []Favourite
+
+	for page := 1; ; page++ {
+		user, err := c.c.GetUserWithContext(ctx, c.username, verniy.UserFieldFavourites(
+			verniy.UserParamFavourites{Page: page},
+			verniy.FavouritesFieldAnime(verniy.FavouritesParamAnime{Page: page, PerPage: 25}, anilistFavouriteMediaFields[0], anilistFavouriteMediaFields[1:]...),
+			verniy.FavouritesFieldManga(verniy.FavouritesParamManga{Page: page, PerPage: 25}, anilistFavouriteMediaFields[0], anilistFavouriteMediaFields[1:]...),
+		))
+		if err != nil {
+			return nil, err
+		}
+		if user.Favourites == nil {
+			break
+		}
+
+		favourites = append(favourites, newFavouritesFromMedia(FavouriteTypeAnime, user.Favourites.Anime)...)
+		favourites = append(favourites, newFavouritesFromMedia(FavouriteTypeManga, user.Favourites.Manga)...)
+
+		if !hasNextPage(user.Favourites.Anime) && !hasNextPage(user.Favourites.Manga) {
+			break
+		}
+	}
+
+	if !characters {
+		return favourites, nil
+	}
+
+	for page := 1; ; page++ {
+		user, err := c.c.GetUserWithContext(ctx, c.username, verniy.UserFieldFavourites(
+			verniy.UserParamFavourites{Page: page},
+			verniy.FavouritesFieldCharacters(verniy.FavouritesParamCharacters{Page: page, PerPage: 25},
+				verniy.CharacterConnectionFieldPageInfo(verniy.PageInfoFieldHasNextPage),
+				verniy.CharacterConnectionFieldNodes(
+					verniy.CharacterFieldID,
+					verniy.CharacterFieldName(verniy.CharacterNameFieldFull),
+				),
+			),
+		))
+		if err != nil {
+			return nil, err
+		}
+		if user.Favourites == nil || user.Favourites.Characters == nil {
+			break
+		}
+
+		for _, ch := range user.Favourites.Characters.Nodes {
+			f := Favourite{Type: FavouriteTypeCharacter, AnilistID: ch.ID}
+			if ch.Name != nil && ch.Name.Full != nil {
+				f.Title = *ch.Name.Full
+			}
+			favourites = append(favourites, f)
+		}
+
+		info := user.Favourites.Characters.PageInfo
+		if info == nil || info.HasNextPage == nil || !*info.HasNextPage {
+			break
+		}
+	}
+
+	return favourites, nil
+}
+
+func (c *AnilistClient) GetAnimeByMalID(ctx context.Context, id int) (*verniy.Media, error) {
+	return c.getMediaByMalID(ctx, id, verniy.MediaTypeAnime, anilistAnimeMediaFields)
+}
+
+func (c *AnilistClient) GetMangaByMalID(ctx context.Context, id int) (*verniy.Media, error) {
+	return c.getMediaByMalID(ctx, id, verniy.MediaTypeManga, anilistMangaMediaFields)
+}
+
+func (c *AnilistClient) GetAnimesByName(ctx context.Context, name string) ([]verniy.Media, error) {
+	fields := withMediaListEntry(anilistAnimeMediaFields)
+
+	page, err := c.c.SearchAnimeWithContext(ctx, verniy.PageParamMedia{Search: name}, 1, 3, fields...)
+	if err != nil {
+		return nil, err
+	}
+
+	return page.Media, nil
+}
+
+func (c *AnilistClient) GetMangasByName(ctx context.Context, name string) ([]verniy.Media, error) {
+	fields := withMediaListEntry(anilistMangaMediaFields)
+
+	page, err := c.c.SearchMangaWithContext(ctx, verniy.PageParamMedia{Search: name}, 1, 10, fields...)
+	if err != nil {
+		return nil, err
+	}
+
+	return page.Media, nil
+}
+
+// SaveMediaListEntry creates or updates the user's list entry for the media.
+// Only the variables present in vars are sent, so absent fields stay untouched.
+func (c *AnilistClient) SaveMediaListEntry(ctx context.Context, mediaID int, vars map[string]any) error {
+	if len(vars) == 0 {
+		return nil
+	}
+
+	v := make(map[string]any, len(vars)+1)
+	for k, val := range vars {
+		v[k] = val
+	}
+	v["mediaId"] = mediaID
+
+	return c.query(ctx, saveMediaListEntryMutation, v, nil)
+}
+
+func (c *AnilistClient) getMediaByMalID(ctx context.Context, id int, mediaType verniy.MediaType, fields []verniy.MediaField) (*verniy.Media, error) {
+	if id <= 0 {
+		return nil, errEmptyMalID
+	}
+
+	fields = withMediaListEntry(fields)
+
+	str := make([]string, len(fields))
+	for i := range fields {
+		str[i] = string(fields[i])
+	}
+
+	query := verniy.FieldObject("query", verniy.QueryParam{
+		"$idMal": "Int",
+		"$type":  "MediaType",
+	}, verniy.FieldObject("Media", verniy.QueryParam{
+		"idMal": "$idMal",
+		"type":  "$type",
+	}, str...))
+
+	var resp struct {
+		Data struct {
+			Media verniy.Media `json:"Media"`
+		} `json:"data"`
+	}
+	if err := c.query(ctx, query, map[string]any{"idMal": id, "type": mediaType}, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp.Data.Media, nil
+}
+
+// GetViewerName returns the name of the authorized user.
+func (c *AnilistClient) GetViewerName(ctx context.Context) (string, error) {
+	var resp struct {
+		Data struct {
+			Viewer struct {
+				Name string `json:"name"`
+			} `json:"Viewer"`
+		} `json:"data"`
+	}
+	if err := c.query(ctx, "query { Viewer { name } }", nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.Data.Viewer.Name, nil
+}
+
+func (c *AnilistClient) query(ctx context.Context, query string, vars map[string]any, v any) error {
+	body, err := json.Marshal(map[string]any{
+		"query":     query,
+		"variables": vars,
+	})
+	if err != nil {
+		return err
+	}
+
+	data, code, err := c.c.MakeRequest(ctx, body)
+	if err != nil {
+		return err
+	}
+
+	var errResp struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if code != http.StatusOK {
+		if err := json.Unmarshal(data, &errResp); err == nil && len(errResp.Errors) > 0 {
+			return fmt.Errorf("anilist: %d: %s", code, errResp.Errors[0].Message)
+		}
+		return fmt.Errorf("anilist: unexpected status code: %d", code)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// withMediaListEntry returns a copy of fields that also requests the viewer's list entry.
+func withMediaListEntry(fields []verniy.MediaField) []verniy.MediaField {
+	res := make([]verniy.MediaField, 0, len(fields)+1)
+	res = append(res, fields...)
+	return append(res, verniy.MediaFieldMediaListEntry(anilistMediaListEntryFields[0], anilistMediaListEntryFields[1:]...))
+}
+
 func NewAnilistOAuth(ctx context.Context, config Config) (*OAuth, error) {
-	oauthAnilist, err := NewOAuth(
+	oauthAnilist, err := newAnilistOAuth(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if oauthAnilist.NeedInit() {
+		if err := authorize(ctx, oauthAnilist, config.OAuth); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Println("Token already set, no need to start server")
+	}
+
+	return oauthAnilist, nil
+}
+
+// newAnilistOAuth loads the AniList token without starting the authorization flow.
+func newAnilistOAuth(ctx context.Context, config Config) (*OAuth, error) {
+	ctx, err := withHTTPClient(ctx, config.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewOAuth(
 		ctx,
 		config.Anilist,
-		config.OAuth.RedirectURI,
+		config.Anilist.redirectURI(config.OAuth),
 		"anilist",
 		[]oauth2.AuthCodeOption{
 			oauth2.AccessTypeOffline,
 		},
 		config.TokenFilePath,
 	)
+}
+
+func newFavouritesFromMedia(kind string, conn *verniy.MediaConnection) []Favourite {
+	if conn == nil {
+		return nil
+	}
+
+	favourites := make([]Favourite, 0, len(conn.Nodes))
+	for _, media := range conn.Nodes {
+		f := Favourite{Type: kind, AnilistID: media.ID}
+		if media.IDMAL != nil {
+			f.MalID = *media.IDMAL
+		}
+		if media.Title != nil {
+			if media.Title.Romaji != nil {
+				f.Title = *media.Title.Romaji
+			} else if media.Title.English != nil {
+				f.Title = *media.Title.English
+			}
+		}
+		favourites = append(favourites, f)
+	}
+	return favourites
+}
+
+func hasNextPage(conn *verniy.MediaConnection) bool {
+	return conn != nil && conn.PageInfo != nil && conn.PageInfo.HasNextPage != nil && *conn.PageInfo.HasNextPage
+}
+
+// anilistAnimeTracker resolves AniList media by their idMal.
+type anilistAnimeTracker struct {
+	c      *AnilistClient
+	scores ScoreConverter
+	lists  CustomListTags
+	extra  ExtraFields
+}
+
+func (t *anilistAnimeTracker) Name() string { return "AniList" }
+
+func (t *anilistAnimeTracker) GetList(ctx context.Context) ([]Entry, error) {
+	list, err := t.c.GetUserAnimeList(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error getting user anime list from anilist: %w", err)
 	}
+	return newEntriesFromAnimes(newAnimesFromMediaListGroups(list, t.scores, t.lists, t.extra)), nil
+}
 
-	if oauthAnilist.NeedInit() {
-		getToken(ctx, oauthAnilist, config.OAuth.Port)
-	} else {
-		log.Println("Token already set, no need to start server")
+func (t *anilistAnimeTracker) GetByID(ctx context.Context, id TargetID) (Target, error) {
+	resp, err := t.c.GetAnimeByMalID(ctx, int(id))
+	if err != nil {
+		return nil, fmt.Errorf("error getting anime by mal id: %w", err)
+	}
+	ani, err := newAnimeFromAnilistMedia(*resp, t.scores)
+	if err != nil {
+		return nil, fmt.Errorf("error creating anime from anilist media: %w", err)
 	}
+	return ani, nil
+}
 
-	return oauthAnilist, nil
+func (t *anilistAnimeTracker) Search(ctx context.Context, name string) ([]Target, error) {
+	resp, err := t.c.GetAnimesByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting anime by name: %w", err)
+	}
+	return newTargetsFromAnimes(newAnimesFromAnilistMedias(resp, t.scores)), nil
+}
+
+func (t *anilistAnimeTracker) Update(ctx context.Context, id TargetID, src Source) error {
+	a, ok := src.(Anime)
+	if !ok {
+		return fmt.Errorf("source is not an anime")
+	}
+	media, err := t.c.GetAnimeByMalID(ctx, int(id))
+	if err != nil {
+		return fmt.Errorf("error getting anime by mal id: %w", err)
+	}
+	opts := a.GetAnilistUpdateOptions()
+	if opts != nil {
+		opts["scoreRaw"] = t.scores.RawFromMal(a.Score)
+	}
+	if err := t.c.SaveMediaListEntry(ctx, media.ID, opts); err != nil {
+		return fmt.Errorf("error saving anime list entry: %w", err)
+	}
+	return nil
+}
+
+// anilistMangaTracker resolves AniList media by their idMal.
+type anilistMangaTracker struct {
+	c      *AnilistClient
+	scores ScoreConverter
+	lists  CustomListTags
+	extra  ExtraFields
+}
+
+func (t *anilistMangaTracker) Name() string { return "AniList" }
+
+func (t *anilistMangaTracker) GetList(ctx context.Context) ([]Entry, error) {
+	list, err := t.c.GetUserMangaList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user manga list from anilist: %w", err)
+	}
+	return newEntriesFromMangas(newMangasFromMediaListGroups(list, t.scores, t.lists, t.extra)), nil
+}
+
+func (t *anilistMangaTracker) GetByID(ctx context.Context, id TargetID) (Target, error) {
+	resp, err := t.c.GetMangaByMalID(ctx, int(id))
+	if err != nil {
+		return nil, fmt.Errorf("error getting manga by mal id: %w", err)
+	}
+	m, err := newMangaFromAnilistMedia(*resp, t.scores)
+	if err != nil {
+		return nil, fmt.Errorf("error creating manga from anilist media: %w", err)
+	}
+	return m, nil
+}
+
+func (t *anilistMangaTracker) Search(ctx context.Context, name string) ([]Target, error) {
+	resp, err := t.c.GetMangasByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting manga by name: %w", err)
+	}
+	return newTargetsFromMangas(newMangasFromAnilistMedias(resp, t.scores)), nil
+}
+
+func (t *anilistMangaTracker) Update(ctx context.Context, id TargetID, src Source) error {
+	m, ok := src.(Manga)
+	if !ok {
+		return fmt.Errorf("source is not a manga")
+	}
+	media, err := t.c.GetMangaByMalID(ctx, int(id))
+	if err != nil {
+		return fmt.Errorf("error getting manga by mal id: %w", err)
+	}
+	opts := m.GetAnilistUpdateOptions()
+	if opts != nil {
+		opts["scoreRaw"] = t.scores.RawFromMal(m.Score)
+	}
+	if err := t.c.SaveMediaListEntry(ctx, media.ID, opts); err != nil {
+		return fmt.Errorf("error saving manga list entry: %w", err)
+	}
+	return nil
 }
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -14,6 +16,9 @@ import (
 
 var errStatusUnknown = errors.New("status unknown")
 
+// malPrivateTag marks AniList private entries on MAL, which has no private entries.
+const malPrivateTag = "private"
+
 var betweenBraketsRegexp = regexp.MustCompile(`\(.*\)`)
 
 type Status string
@@ -44,6 +49,23 @@ func (s Status) GetMalStatus() (mal.AnimeStatus, error) {
 	}
 }
 
+func (s Status) GetAnilistStatus() (verniy.MediaListStatus, error) {
+	switch s {
+	case StatusWatching:
+		return verniy.MediaListStatusCurrent, nil
+	case StatusCompleted:
+		return verniy.MediaListStatusCompleted, nil
+	case StatusOnHold:
+		return verniy.MediaListStatusPaused, nil
+	case StatusDropped:
+		return verniy.MediaListStatusDropped, nil
+	case StatusPlanToWatch:
+		return verniy.MediaListStatusPlanning, nil
+	default:
+		return "", errStatusUnknown
+	}
+}
+
 type Anime struct {
 	NumEpisodes int
 	IDAnilist   int
@@ -51,18 +73,50 @@ type Anime struct {
 	Progress    int
 	Score       float64
 	SeasonYear  int
+	MediaType   string
 	Status      Status
 	TitleEN     string
 	TitleJP     string
 	TitleRomaji string
 	StartedAt   *time.Time
 	FinishedAt  *time.Time
+	UpdatedAt   time.Time
+
+	IsRewatching      bool
+	NumTimesRewatched int
+
+	Notes   string
+	Private bool
+	// Tags are the MAL tags, from AniList custom lists when AniList is the source.
+	Tags []string
+	// ManagedTags are the MAL tags the custom lists are exported as, nil when no custom list is exported.
+	// The other MAL tags are kept.
+	ManagedTags []string
+	// scores is the AniList score format of entries from AniList, the zero value for the others.
+	scores ScoreConverter
+	// tagsMerged is set when Tags include the MAL tags of the target, targetPrivate when it is tagged as private.
+	tagsMerged    bool
+	targetPrivate bool
+	// clearNotes writes empty notes too, clearing the notes of the target.
+	clearNotes bool
+
+	// Priority and RewatchValue are MAL only fields, nil when not managed.
+	Priority     *int
+	RewatchValue *int
 }
 
 func (a Anime) GetTargetID() TargetID {
 	return TargetID(a.IDMal)
 }
 
+func (a Anime) GetAnilistID() int {
+	return a.IDAnilist
+}
+
+func (a Anime) GetUpdatedAt() time.Time {
+	return a.UpdatedAt
+}
+
 func (a Anime) GetStatusString() string {
 	return string(a.Status)
 }
@@ -78,7 +132,7 @@ func (a Anime) GetStringDiffWithTarget(t Target) string {
 	if a.Status != b.Status {
 		sb.WriteString(fmt.Sprintf("Status: %s -> %s, ", a.Status, b.Status))
 	}
-	if a.Score != b.Score {
+	if !a.scoreConverter(b).SameScore(a.Score, b.Score) {
 		sb.WriteString(fmt.Sprintf("Score: %f -> %f, ", a.Score, b.Score))
 	}
 	if a.Progress != b.Progress {
@@ -87,46 +141,97 @@ func (a Anime) GetStringDiffWithTarget(t Target) string {
 	if a.NumEpisodes != b.NumEpisodes {
 		sb.WriteString(fmt.Sprintf("NumEpisodes: %d -> %d, ", a.NumEpisodes, b.NumEpisodes))
 	}
+	if a.IsRewatching != b.IsRewatching {
+		sb.WriteString(fmt.Sprintf("IsRewatching: %t -> %t, ", a.IsRewatching, b.IsRewatching))
+	}
+	if a.NumTimesRewatched != b.NumTimesRewatched {
+		sb.WriteString(fmt.Sprintf("NumTimesRewatched: %d -> %d, ", a.NumTimesRewatched, b.NumTimesRewatched))
+	}
+	if a.writesNotes() && a.Notes != b.Notes {
+		sb.WriteString(fmt.Sprintf("Notes: %q -> %q, ", a.Notes, b.Notes))
+	}
+	if a.Private != b.Private {
+		sb.WriteString(fmt.Sprintf("Private: %t -> %t, ", a.Private, b.Private))
+	}
+	if a.ManagedTags != nil && !slices.Equal(mergeMalTags(b.Tags, a.Tags, a.ManagedTags), b.Tags) {
+		sb.WriteString(fmt.Sprintf("Tags: %v -> %v, ", a.Tags, b.Tags))
+	}
+	if !sameOptionalInt(a.Priority, b.Priority) {
+		sb.WriteString(fmt.Sprintf("Priority: %d -> %d, ", *a.Priority, optionalInt(b.Priority)))
+	}
+	if !sameOptionalInt(a.RewatchValue, b.RewatchValue) {
+		sb.WriteString(fmt.Sprintf("RewatchValue: %d -> %d, ", *a.RewatchValue, optionalInt(b.RewatchValue)))
+	}
 	sb.WriteString("}")
 	return sb.String()
 }
 
-func (a Anime) SameProgressWithTarget(t Target) bool {
+// SameProgressWithTarget reports whether the target is up to date, debugf explains the difference.
+func (a Anime) SameProgressWithTarget(t Target, debugf func(format string, v ...any)) bool {
 	b, ok := t.(Anime)
 	if !ok {
 		return false
 	}
 
 	if a.Status != b.Status {
-		DPrintf("Status: %s != %s", a.Status, b.Status)
+		debugf("Status: %s != %s", a.Status, b.Status)
+		return false
+	}
+	if !a.scoreConverter(b).SameScore(a.Score, b.Score) {
+		debugf("Score: %f != %f", a.Score, b.Score)
+		return false
+	}
+	if a.IsRewatching != b.IsRewatching {
+		debugf("IsRewatching: %t != %t", a.IsRewatching, b.IsRewatching)
+		return false
+	}
+	if a.NumTimesRewatched != b.NumTimesRewatched {
+		debugf("NumTimesRewatched: %d != %d", a.NumTimesRewatched, b.NumTimesRewatched)
+		return false
+	}
+	if a.writesNotes() && a.Notes != b.Notes {
+		debugf("Notes: %q != %q", a.Notes, b.Notes)
+		return false
+	}
+	if a.Private != b.Private {
+		debugf("Private: %t != %t", a.Private, b.Private)
+		return false
+	}
+	// tags are only pushed to MAL, AniList custom lists are never written
+	if a.ManagedTags != nil && !slices.Equal(mergeMalTags(b.Tags, a.Tags, a.ManagedTags), b.Tags) {
+		debugf("Tags: %v != %v", a.Tags, b.Tags)
 		return false
 	}
-	if a.Score != b.Score {
-		DPrintf("Score: %f != %f", a.Score, b.Score)
+	if !sameOptionalInt(a.Priority, b.Priority) {
+		debugf("Priority: %d != %d", *a.Priority, optionalInt(b.Priority))
+		return false
+	}
+	if !sameOptionalInt(a.RewatchValue, b.RewatchValue) {
+		debugf("RewatchValue: %d != %d", *a.RewatchValue, optionalInt(b.RewatchValue))
 		return false
 	}
 	progress := a.Progress == b.Progress
 	if a.NumEpisodes == b.NumEpisodes {
-		DPrintf("Equal number of episodes: %d == %d", a.NumEpisodes, b.NumEpisodes)
-		DPrintf("Progress: %t", progress)
+		debugf("Equal number of episodes: %d == %d", a.NumEpisodes, b.NumEpisodes)
+		debugf("Progress: %t", progress)
 		return progress
 	}
 	if a.NumEpisodes == 0 || b.NumEpisodes == 0 {
-		DPrintf("One of the anime has 0 episodes: %d, %d", a.NumEpisodes, b.NumEpisodes)
-		DPrintf("Progress: %t", progress)
+		debugf("One of the anime has 0 episodes: %d, %d", a.NumEpisodes, b.NumEpisodes)
+		debugf("Progress: %t", progress)
 		return progress
 	}
 	if progress && (a.NumEpisodes-b.NumEpisodes != 0) {
-		DPrintf("Both anime have 0 progress but different number of episodes: %d, %d", a.NumEpisodes, b.NumEpisodes)
+		debugf("Both anime have 0 progress but different number of episodes: %d, %d", a.NumEpisodes, b.NumEpisodes)
 		return true
 	}
 
 	aa := (a.NumEpisodes - a.Progress)
 	bb := (b.NumEpisodes - b.Progress)
 
-	DPrintf("Number of episodes: %d, %d", a.NumEpisodes, b.NumEpisodes)
-	DPrintf("Progress: %d, %d", a.Progress, b.Progress)
-	DPrintf("Progress: %d == %d", aa, bb)
+	debugf("Number of episodes: %d, %d", a.NumEpisodes, b.NumEpisodes)
+	debugf("Progress: %d, %d", a.Progress, b.Progress)
+	debugf("Progress: %d == %d", aa, bb)
 
 	return aa == bb
 }
@@ -207,18 +312,152 @@ func (a Anime) GetUpdateOptions() []mal.UpdateMyAnimeListStatusOption {
 		st,
 		mal.Score(a.Score),
 		mal.NumEpisodesWatched(a.Progress),
+		mal.IsRewatching(a.IsRewatching),
+		mal.NumTimesRewatched(a.NumTimesRewatched),
+	}
+
+	if a.writesNotes() {
+		opts = append(opts, mal.Comments(a.Notes))
+	}
+
+	// tags are written only when they are used and the MAL tags are known, others would clear the MAL tags
+	if a.writesTags() {
+		opts = append(opts, mal.Tags(a.malTags()))
 	}
 
 	if a.StartedAt != nil {
 		opts = append(opts, mal.StartDate(*a.StartedAt))
-	} else {
-		opts = append(opts, mal.StartDate(time.Time{}))
 	}
 
 	if a.Status == StatusCompleted && a.FinishedAt != nil {
 		opts = append(opts, mal.FinishDate(*a.FinishedAt))
+	}
+
+	if a.Priority != nil {
+		opts = append(opts, mal.Priority(*a.Priority))
+	}
+
+	if a.RewatchValue != nil {
+		opts = append(opts, mal.RewatchValue(*a.RewatchValue))
+	}
+
+	return opts
+}
+
+// ApplyPolicy copies the fields the policy doesn't let to write from the target.
+// The status is always written to entries without a status on the target.
+func (a Anime) ApplyPolicy(p SyncPolicy, t Target) Source {
+	b, ok := t.(Anime)
+	if !ok {
+		return a
+	}
+
+	if b.Status != StatusUnknown && b.Status != "" && p.Status.keep(false) {
+		a.Status = b.Status
+	}
+	if p.Score.keep(b.Score == 0) {
+		a.Score = b.Score
+	}
+	if p.Progress.keep(b.Progress == 0) {
+		a.Progress = b.Progress
+	}
+	if p.Dates.keep(b.StartedAt == nil) {
+		a.StartedAt = b.StartedAt
+	}
+	if p.Dates.keep(b.FinishedAt == nil) {
+		a.FinishedAt = b.FinishedAt
+	}
+	if p.Notes.keep(b.Notes == "") {
+		a.Notes = b.Notes
 	} else {
-		opts = append(opts, mal.FinishDate(time.Time{}))
+		a.clearNotes = p.ClearNotes
+	}
+	if p.Rewatch.keep(b.NumTimesRewatched == 0 && !b.IsRewatching) {
+		a.NumTimesRewatched = b.NumTimesRewatched
+		a.IsRewatching = b.IsRewatching
+	}
+
+	return a
+}
+
+// scoreConverter returns the AniList score format of the entry or the other one.
+func (a Anime) scoreConverter(b Anime) ScoreConverter {
+	if a.scores.Format != "" {
+		return a.scores
+	}
+	return b.scores
+}
+
+// MergeTarget adds the MAL tags the custom lists don't manage, the update would remove them otherwise.
+func (a Anime) MergeTarget(t Target) Source {
+	b, ok := t.(Anime)
+	if !ok || a.IDAnilist <= 0 {
+		return a
+	}
+
+	a.Tags = mergeMalTags(b.Tags, a.Tags, a.ManagedTags)
+	a.tagsMerged = true
+	a.targetPrivate = b.Private
+	return a
+}
+
+// NeedsTarget reports whether the entry has tags to write, they need the MAL tags of the target.
+func (a Anime) NeedsTarget() bool {
+	return a.IDAnilist > 0 && (a.ManagedTags != nil || a.Private)
+}
+
+// writesNotes reports whether the update writes the notes, empty notes only when the policy clears them.
+func (a Anime) writesNotes() bool {
+	return a.Notes != "" || a.clearNotes
+}
+
+// writesTags reports whether the update writes the tags: custom lists are exported or the private tag
+// is added or removed, and the MAL tags are merged.
+func (a Anime) writesTags() bool {
+	return a.IDAnilist > 0 && a.tagsMerged && (a.ManagedTags != nil || a.Private || a.targetPrivate)
+}
+
+// malTags returns the MAL tags for the entry, AniList private entries are also tagged as private.
+func (a Anime) malTags() []string {
+	tags := slices.Clone(a.Tags)
+	if a.Private {
+		tags = append(tags, malPrivateTag)
+	}
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+func (a Anime) GetAnilistUpdateOptions() map[string]any {
+	st, err := a.Status.GetAnilistStatus()
+	if err != nil {
+		log.Printf("Error getting AniList status: %v", err)
+		return nil
+	}
+
+	if a.IsRewatching {
+		st = verniy.MediaListStatusRepeating
+	}
+
+	opts := map[string]any{
+		"status":   st,
+		"scoreRaw": int(math.Round(a.Score * 10)),
+		"progress": a.Progress,
+		"repeat":   a.NumTimesRewatched,
+		"private":  a.Private,
+	}
+
+	if a.writesNotes() {
+		opts["notes"] = a.Notes
+	}
+
+	if a.StartedAt != nil {
+		opts["startedAt"] = convertTimeToFuzzyDate(a.StartedAt)
+	}
+
+	if a.Status == StatusCompleted && a.FinishedAt != nil {
+		opts["completedAt"] = convertTimeToFuzzyDate(a.FinishedAt)
 	}
 
 	return opts
@@ -234,6 +473,21 @@ func (a Anime) GetTitle() string {
 	return a.TitleRomaji
 }
 
+func (a Anime) GetTitles() []string {
+	titles := make([]string, 0, 3)
+	for _, title := range []string{a.TitleEN, a.TitleJP, a.TitleRomaji} {
+		if title != "" {
+			titles = append(titles, title)
+		}
+	}
+	return titles
+}
+
+// Describe returns a short human readable description to tell similar entries apart.
+func (a Anime) Describe() string {
+	return fmt.Sprintf("%s (%d, %s, %d eps) [id %d]", a.GetTitle(), a.SeasonYear, a.MediaType, a.NumEpisodes, a.IDMal)
+}
+
 func (a Anime) String() string {
 	sb := strings.Builder{}
 	sb.WriteString("Anime{")
@@ -247,28 +501,39 @@ func (a Anime) String() string {
 	sb.WriteString(fmt.Sprintf("EpisodeNumber: %d, ", a.NumEpisodes))
 	sb.WriteString(fmt.Sprintf("SeasonYear: %d, ", a.SeasonYear))
 	sb.WriteString(fmt.Sprintf("StartedAt: %s, ", a.StartedAt))
-	sb.WriteString(fmt.Sprintf("FinishedAt: %s", a.FinishedAt))
+	sb.WriteString(fmt.Sprintf("FinishedAt: %s, ", a.FinishedAt))
+	sb.WriteString(fmt.Sprintf("UpdatedAt: %s, ", a.UpdatedAt))
+	sb.WriteString(fmt.Sprintf("IsRewatching: %t, ", a.IsRewatching))
+	sb.WriteString(fmt.Sprintf("NumTimesRewatched: %d", a.NumTimesRewatched))
 	sb.WriteString("}")
 	return sb.String()
 }
 
-func newAnimesFromMediaListGroups(groups []verniy.MediaListGroup) []Anime {
-	res := make([]Anime, 0, len(groups))
-	for _, group := range groups {
-		for _, mediaList := range group.Entries {
-			a, err := newAnimeFromMediaListEntry(mediaList)
-			if err != nil {
-				log.Printf("Error creating anime from media list entry: %v", err)
-				continue
-			}
+func newAnimesFromMediaListGroups(groups []verniy.MediaListGroup, scores ScoreConverter, lists CustomListTags, extra ExtraFields) []Anime {
+	customLists := customListsByMediaID(groups)
+	managed := lists.ManagedTags(groups)
+
+	entries := uniqueMediaListEntries(groups)
 
-			res = append(res, a)
+	res := make([]Anime, 0, len(entries))
+	for _, mediaList := range entries {
+		a, err := newAnimeFromMediaListEntry(mediaList, scores)
+		if err != nil {
+			log.Printf("Error creating anime from media list entry: %v", err)
+			continue
 		}
+		names := customLists[a.IDAnilist]
+		a.Tags = lists.Tags(names)
+		a.ManagedTags = managed
+		a.Priority = extra.Priority.Value(names, mediaList.Priority)
+		a.RewatchValue = extra.RewatchValue.Value(names, nil)
+
+		res = append(res, a)
 	}
 	return res
 }
 
-func newAnimeFromMediaListEntry(mediaList verniy.MediaList) (Anime, error) {
+func newAnimeFromMediaListEntry(mediaList verniy.MediaList, scores ScoreConverter) (Anime, error) {
 	if mediaList.Media == nil {
 		return Anime{}, errors.New("media is nil")
 	}
@@ -283,7 +548,7 @@ func newAnimeFromMediaListEntry(mediaList verniy.MediaList) (Anime, error) {
 
 	var score float64
 	if mediaList.Score != nil {
-		score = *mediaList.Score
+		score = scores.ToMal(*mediaList.Score)
 	}
 
 	var progress int
@@ -311,6 +576,11 @@ func newAnimeFromMediaListEntry(mediaList verniy.MediaList) (Anime, error) {
 		year = *mediaList.Media.SeasonYear
 	}
 
+	var mediaType string
+	if mediaList.Media.Format != nil {
+		mediaType = strings.ToLower(string(*mediaList.Media.Format))
+	}
+
 	var idMal int
 	if mediaList.Media.IDMAL != nil {
 		idMal = *mediaList.Media.IDMAL
@@ -321,6 +591,21 @@ func newAnimeFromMediaListEntry(mediaList verniy.MediaList) (Anime, error) {
 		romajiTitle = *mediaList.Media.Title.Romaji
 	}
 
+	var repeat int
+	if mediaList.Repeat != nil {
+		repeat = *mediaList.Repeat
+	}
+
+	var updatedAt time.Time
+	if mediaList.UpdatedAt != nil {
+		updatedAt = time.Unix(int64(*mediaList.UpdatedAt), 0).UTC()
+	}
+
+	var notes string
+	if mediaList.Notes != nil {
+		notes = *mediaList.Notes
+	}
+
 	startedAt := convertFuzzyDateToTimeOrNow(mediaList.StartedAt)
 	finishedAt := convertFuzzyDateToTimeOrNow(mediaList.CompletedAt)
 
@@ -331,15 +616,48 @@ func newAnimeFromMediaListEntry(mediaList verniy.MediaList) (Anime, error) {
 		Progress:    progress,
 		Score:       score,
 		SeasonYear:  year,
+		MediaType:   mediaType,
 		Status:      mapVerniyStatusToStatus(*mediaList.Status),
 		TitleEN:     titleEN,
 		TitleJP:     titleJP,
 		TitleRomaji: romajiTitle,
 		StartedAt:   startedAt,
 		FinishedAt:  finishedAt,
+		UpdatedAt:   updatedAt,
+		scores:      scores,
+
+		IsRewatching:      *mediaList.Status == verniy.MediaListStatusRepeating,
+		NumTimesRewatched: repeat,
+
+		Notes:   notes,
+		Private: mediaList.Private != nil && *mediaList.Private,
 	}, nil
 }
 
+func newAnimesFromAnilistMedias(medias []verniy.Media, scores ScoreConverter) []Anime {
+	res := make([]Anime, 0, len(medias))
+	for _, media := range medias {
+		a, err := newAnimeFromAnilistMedia(media, scores)
+		if err != nil {
+			log.Printf("failed to convert anilist media to anime: %v", err)
+			continue
+		}
+		res = append(res, a)
+	}
+	return res
+}
+
+// newAnimeFromAnilistMedia builds an anime from a media with the viewer's list entry.
+// If the media is not in the list yet, the status is unknown.
+func newAnimeFromAnilistMedia(media verniy.Media, scores ScoreConverter) (Anime, error) {
+	entry := verniy.MediaList{Status: new(verniy.MediaListStatus)}
+	if media.MediaListEntry != nil {
+		entry = *media.MediaListEntry
+	}
+	entry.Media = &media
+	return newAnimeFromMediaListEntry(entry, scores)
+}
+
 func newAnimesFromMalAnimes(malAnimes []mal.Anime) []Anime {
 	res := make([]Anime, 0, len(malAnimes))
 	for _, malAnime := range malAnimes {
@@ -391,14 +709,36 @@ func newAnimeFromMalAnime(malAnime mal.Anime) (Anime, error) {
 		Progress:    malAnime.MyListStatus.NumEpisodesWatched,
 		Score:       float64(malAnime.MyListStatus.Score),
 		SeasonYear:  malAnime.StartSeason.Year,
+		MediaType:   malAnime.MediaType,
 		Status:      mapMalAnimeStatusToStatus(malAnime.MyListStatus.Status),
 		TitleEN:     titleEN,
 		TitleJP:     titleJP,
 		StartedAt:   startedAt,
 		FinishedAt:  finishedAt,
+		UpdatedAt:   malAnime.MyListStatus.UpdatedAt,
+
+		IsRewatching:      malAnime.MyListStatus.IsRewatching,
+		NumTimesRewatched: malAnime.MyListStatus.NumTimesRewatched,
+
+		Notes:   malAnime.MyListStatus.Comments,
+		Private: hasMalPrivateTag(malAnime.MyListStatus.Tags),
+		Tags:    malTagsWithoutPrivate(malAnime.MyListStatus.Tags),
+
+		Priority:     &malAnime.MyListStatus.Priority,
+		RewatchValue: &malAnime.MyListStatus.RewatchValue,
 	}, nil
 }
 
+// hasMalPrivateTag reports whether the MAL entry is tagged as private.
+func hasMalPrivateTag(tags []string) bool {
+	for _, tag := range tags {
+		if strings.EqualFold(tag, malPrivateTag) {
+			return true
+		}
+	}
+	return false
+}
+
 func mapVerniyStatusToStatus(s verniy.MediaListStatus) Status {
 	switch s {
 	case verniy.MediaListStatusCurrent:
@@ -412,7 +752,7 @@ func mapVerniyStatusToStatus(s verniy.MediaListStatus) Status {
 	case verniy.MediaListStatusPlanning:
 		return StatusPlanToWatch
 	case verniy.MediaListStatusRepeating:
-		return StatusWatching // TODO: handle repeating correctly
+		return StatusCompleted // MAL keeps rewatching entries completed with the rewatching flag
 	default:
 		return StatusUnknown
 	}
@@ -449,6 +789,13 @@ func convertFuzzyDateToTimeOrNow(fd *verniy.FuzzyDate) *time.Time {
 	return &d
 }
 
+func convertTimeToFuzzyDate(t *time.Time) map[string]any {
+	if t == nil {
+		return map[string]any{"year": nil, "month": nil, "day": nil}
+	}
+	return map[string]any{"year": t.Year(), "month": int(t.Month()), "day": t.Day()}
+}
+
 func parseDateOrNow(dateStr string) *time.Time {
 	if dateStr == "" {
 		return nil
@@ -469,8 +816,8 @@ func newTargetsFromAnimes(animes []Anime) []Target {
 	return res
 }
 
-func newSourcesFromAnimes(animes []Anime) []Source {
-	res := make([]Source, 0, len(animes))
+func newEntriesFromAnimes(animes []Anime) []Entry {
+	res := make([]Entry, 0, len(animes))
 	for _, anime := range animes {
 		res = append(res, anime)
 	}
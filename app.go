@@ -4,199 +4,255 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 )
 
+// SyncDirection is the direction between the source and the target trackers,
+// the names come from the default AniList source and MAL target.
+type SyncDirection string
+
+const (
+	DirectionAnilistToMal SyncDirection = "anilist-to-mal"
+	DirectionMalToAnilist SyncDirection = "mal-to-anilist"
+	DirectionBoth         SyncDirection = "both"
+)
+
+func (d SyncDirection) Valid() bool {
+	switch d {
+	case DirectionAnilistToMal, DirectionMalToAnilist, DirectionBoth:
+		return true
+	default:
+		return false
+	}
+}
+
 type App struct {
 	config Config
+	opts   Options
 
-	mal     *MyAnimeListClient
+	// anilist is nil when AniList is neither the source nor the target
 	anilist *AnilistClient
+	oauths  []*OAuth
+
+	// sources and targets of the updaters, swapped for the mal-to-anilist direction
+	animeSource, animeTarget Tracker
+	mangaSource, mangaTarget Tracker
+
+	favourites FavouritesWriter
+	orphans    *Orphans
 
 	animeUpdater *Updater
 	mangaUpdater *Updater
 }
 
-func NewApp(ctx context.Context, config Config) (*App, error) {
-	oauthMAL, err := NewMyAnimeListOAuth(ctx, config)
+func NewApp(ctx context.Context, config Config, opts Options) (*App, error) {
+	saved, err := readMappingsFile(config.MappingsFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("error creating mal oauth: %w", err)
+		return nil, fmt.Errorf("error reading mappings file: %w", err)
 	}
 
-	log.Println("Got MAL token")
+	// mappings from the config take precedence over the saved choices
+	animeMappings := NewMappings(defaultAnimeMappings, saved.Anime, config.Mappings.Anime)
+	mangaMappings := NewMappings(saved.Manga, config.Mappings.Manga)
+
+	animeIgnores, err := NewIgnoreRules(config.Ignore.Anime, opts.Ignore)
+	if err != nil {
+		return nil, fmt.Errorf("error creating anime ignore rules: %w", err)
+	}
 
-	malClient, err := NewMyAnimeListClient(ctx, oauthMAL, config.MyAnimeList.Username)
+	mangaIgnores, err := NewIgnoreRules(config.Ignore.Manga, opts.Ignore)
 	if err != nil {
-		return nil, fmt.Errorf("error creating mal client: %w", err)
+		return nil, fmt.Errorf("error creating manga ignore rules: %w", err)
 	}
 
-	log.Println("MAL client created")
+	if err := config.SyncPolicy.Anime.Validate(); err != nil {
+		return nil, fmt.Errorf("error in anime sync policy: %w", err)
+	}
+
+	if err := config.SyncPolicy.Manga.Validate(); err != nil {
+		return nil, fmt.Errorf("error in manga sync policy: %w", err)
+	}
 
-	oauthAnilist, err := NewAnilistOAuth(ctx, config)
+	clients, err := newTrackerClients(ctx, config, config.Source, config.Target)
 	if err != nil {
-		return nil, fmt.Errorf("error creating anilist oauth: %w", err)
+		return nil, err
 	}
 
-	log.Println("Got Anilist token")
+	animeSource, mangaSource := clients.trackers(config, config.Source)
+	animeTarget, mangaTarget := clients.trackers(config, config.Target)
+	sourceName, targetName := config.Source, config.Target
+	if opts.Direction == DirectionMalToAnilist {
+		animeSource, animeTarget = animeTarget, animeSource
+		mangaSource, mangaTarget = mangaTarget, mangaSource
+		sourceName, targetName = targetName, sourceName
+	}
+
+	animeUpdater := newUpdater("Anime", animeTarget, animeMappings, animeIgnores)
+	mangaUpdater := newUpdater("Manga", mangaTarget, mangaMappings, mangaIgnores)
+	if opts.Direction == DirectionBoth {
+		animeUpdater.Reverse = newUpdater("Anime", animeSource, animeMappings, animeIgnores)
+		mangaUpdater.Reverse = newUpdater("Manga", mangaSource, mangaMappings, mangaIgnores)
+	}
 
-	anilistClient, err := NewAnilistClient(ctx, oauthAnilist, config.Anilist.Username)
+	for _, u := range []*Updater{animeUpdater, mangaUpdater} {
+		u.SourceTracker, u.TargetTracker = sourceName, targetName
+		if u.Reverse != nil {
+			u.Reverse.SourceTracker, u.Reverse.TargetTracker = targetName, sourceName
+		}
+	}
+
+	for _, u := range []*Updater{animeUpdater, mangaUpdater, animeUpdater.Reverse, mangaUpdater.Reverse} {
+		if u != nil {
+			u.Workers = opts.Workers
+			u.Force = opts.Force
+			u.DryRun = opts.DryRun
+			u.Verbose = opts.Verbose
+		}
+	}
+
+	animeUpdater.Policy = config.SyncPolicy.Anime
+	mangaUpdater.Policy = config.SyncPolicy.Manga
+	if animeUpdater.Reverse != nil {
+		animeUpdater.Reverse.Policy = config.SyncPolicy.Anime
+		mangaUpdater.Reverse.Policy = config.SyncPolicy.Manga
+	}
+
+	if opts.Interactive {
+		prompter, err := NewPrompter(stdin, os.Stdout, config.MappingsFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("error creating prompter: %w", err)
+		}
+		animeUpdater.Prompter = prompter
+		mangaUpdater.Prompter = prompter
+	}
+
+	favourites, err := newFavouritesWriter(config.Favourites)
 	if err != nil {
-		return nil, fmt.Errorf("error creating anilist client: %w", err)
-	}
-
-	log.Println("Anilist client created")
-
-	animeUpdater := &Updater{
-		Prefix:     "Anime",
-		Statistics: new(Statistics),
-		IgnoreTitles: map[string]struct{}{ // in lowercase, TODO: move to config
-			"scott pilgrim takes off":       {}, // this anime is not in MAL
-			"bocchi the rock! recap part 2": {}, // this anime is not in MAL
-		},
-
-		GetTargetByIDFunc: func(ctx context.Context, id TargetID) (Target, error) {
-			resp, err := malClient.GetAnimeByID(ctx, int(id))
-			if err != nil {
-				return nil, fmt.Errorf("error getting anime by id: %w", err)
-			}
-			ani, err := newAnimeFromMalAnime(*resp)
-			if err != nil {
-				return nil, fmt.Errorf("error creating anime from mal anime: %w", err)
-			}
-			return ani, nil
-		},
-
-		GetTargetsByNameFunc: func(ctx context.Context, name string) ([]Target, error) {
-			resp, err := malClient.GetAnimesByName(ctx, name)
-			if err != nil {
-				return nil, fmt.Errorf("error getting anime by name: %w", err)
-			}
-			return newTargetsFromAnimes(newAnimesFromMalAnimes(resp)), nil
-		},
-
-		UpdateTargetBySourceFunc: func(ctx context.Context, id TargetID, src Source) error {
-			a, ok := src.(Anime)
-			if !ok {
-				return fmt.Errorf("source is not an anime")
-			}
-			if err := malClient.UpdateAnimeByIDAndOptions(ctx, int(id), a.GetUpdateOptions()); err != nil {
-				return fmt.Errorf("error updating anime by id and options: %w", err)
-			}
-			return nil
-		},
-	}
-
-	mangaUpdater := &Updater{
-		Prefix:       "Manga",
-		Statistics:   new(Statistics),
-		IgnoreTitles: map[string]struct{}{},
-
-		GetTargetByIDFunc: func(ctx context.Context, id TargetID) (Target, error) {
-			resp, err := malClient.GetMangaByID(ctx, int(id))
-			if err != nil {
-				return nil, fmt.Errorf("error getting anime by id: %w", err)
-			}
-			ani, err := newMangaFromMalManga(*resp)
-			if err != nil {
-				return nil, fmt.Errorf("error creating anime from mal anime: %w", err)
-			}
-			return ani, nil
-		},
-
-		GetTargetsByNameFunc: func(ctx context.Context, name string) ([]Target, error) {
-			resp, err := malClient.GetMangasByName(ctx, name)
-			if err != nil {
-				return nil, fmt.Errorf("error getting anime by name: %w", err)
-			}
-			return newTargetsFromMangas(newMangasFromMalMangas(resp)), nil
-		},
-
-		UpdateTargetBySourceFunc: func(ctx context.Context, id TargetID, src Source) error {
-			m, ok := src.(Manga)
-			if !ok {
-				return fmt.Errorf("source is not an anime")
-			}
-			if err := malClient.UpdateMangaByIDAndOptions(ctx, int(id), m.GetUpdateOptions()); err != nil {
-				return fmt.Errorf("error updating anime by id and options: %w", err)
-			}
-			return nil
-		},
+		return nil, fmt.Errorf("error creating favourites writer: %w", err)
+	}
+
+	var orphans *Orphans
+	if opts.Orphans != "" {
+		orphans = NewOrphans()
+		animeUpdater.Orphans = orphans
+		mangaUpdater.Orphans = orphans
 	}
 
 	return &App{
 		config:       config,
-		mal:          malClient,
-		anilist:      anilistClient,
+		opts:         opts,
+		anilist:      clients.anilist,
+		oauths:       clients.oauths,
+		animeSource:  animeSource,
+		animeTarget:  animeTarget,
+		mangaSource:  mangaSource,
+		mangaTarget:  mangaTarget,
+		favourites:   favourites,
+		orphans:      orphans,
 		animeUpdater: animeUpdater,
 		mangaUpdater: mangaUpdater,
 	}, nil
 }
 
 func (a *App) Run(ctx context.Context) error {
-	if *mangaSync || *allSync {
-		if err := a.syncManga(ctx); err != nil {
-			return fmt.Errorf("error syncing manga: %w", err)
-		}
+	if a.opts.Favourites {
+		return a.syncFavourites(ctx)
 	}
-
-	if !(*mangaSync) || *allSync {
-		if err := a.syncAnime(ctx); err != nil {
-			return fmt.Errorf("error syncing anime: %w", err)
-		}
+	if a.opts.Watch {
+		return a.watch(ctx, a.opts.Interval)
 	}
-
-	return nil
+	return a.runOnce(ctx)
 }
 
-func (a *App) syncAnime(ctx context.Context) error {
-	log.Printf("[%s] Fetching AniList...", a.animeUpdater.Prefix)
+func (a *App) runOnce(ctx context.Context) error {
+	var report *Report
+	if a.opts.ReportPath != "" {
+		report = NewReport()
+	}
+
+	a.animeUpdater.Reset(report)
+	a.mangaUpdater.Reset(report)
 
-	srcList, err := a.anilist.GetUserAnimeList(ctx)
+	state, err := readStateFile(a.config.StateFilePath)
 	if err != nil {
-		return fmt.Errorf("error getting user anime list from anilist: %w", err)
+		return fmt.Errorf("error reading state file: %w", err)
 	}
 
-	log.Printf("[%s] Fetching MAL...", a.animeUpdater.Prefix)
+	a.animeUpdater.State = state
+	a.mangaUpdater.State = state
 
-	tgtList, err := a.mal.GetUserAnimeList(ctx)
-	if err != nil {
-		return fmt.Errorf("error getting user anime list from mal: %w", err)
+	if err := a.sync(ctx); err != nil {
+		return err
+	}
+
+	if report != nil {
+		if err := report.WriteFile(a.opts.ReportPath); err != nil {
+			return fmt.Errorf("error writing report: %w", err)
+		}
+		log.Printf("Report written to %s", a.opts.ReportPath)
+	}
+
+	if a.orphans != nil {
+		if err := a.orphans.Write(os.Stdout, a.opts.Orphans); err != nil {
+			return fmt.Errorf("error writing orphans: %w", err)
+		}
+	}
+
+	if a.opts.DryRun {
+		return nil
+	}
+
+	if err := writeStateFile(a.config.StateFilePath, state); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
 	}
 
-	srcAnimes := newSourcesFromAnimes(newAnimesFromMediaListGroups(srcList))
-	tgtAnimes := newTargetsFromAnimes(newAnimesFromMalUserAnimes(tgtList))
+	return nil
+}
 
-	log.Printf("[%s] Got %d from AniList", a.animeUpdater.Prefix, len(srcAnimes))
-	log.Printf("[%s] Got %d from Mal", a.animeUpdater.Prefix, len(tgtAnimes))
+func (a *App) sync(ctx context.Context) error {
+	if a.opts.syncManga() {
+		if err := a.syncManga(ctx); err != nil {
+			return fmt.Errorf("error syncing manga: %w", err)
+		}
+	}
 
-	a.animeUpdater.Update(ctx, srcAnimes, tgtAnimes)
-	a.animeUpdater.Statistics.Print(a.animeUpdater.Prefix)
+	if a.opts.syncAnime() {
+		if err := a.syncAnime(ctx); err != nil {
+			return fmt.Errorf("error syncing anime: %w", err)
+		}
+	}
 
 	return nil
 }
 
+func (a *App) syncAnime(ctx context.Context) error {
+	return a.syncList(ctx, a.animeUpdater, a.animeSource, a.animeTarget)
+}
+
 func (a *App) syncManga(ctx context.Context) error {
-	log.Printf("[%s] Fetching AniList...", a.mangaUpdater.Prefix)
+	return a.syncList(ctx, a.mangaUpdater, a.mangaSource, a.mangaTarget)
+}
 
-	srcList, err := a.anilist.GetUserMangaList(ctx)
+func (a *App) syncList(ctx context.Context, u *Updater, src, tgt Tracker) error {
+	log.Printf("[%s] Fetching %s...", u.Prefix, src.Name())
+
+	srcs, err := src.GetList(ctx)
 	if err != nil {
-		return fmt.Errorf("error getting user anime list from anilist: %w", err)
+		return err
 	}
 
-	log.Printf("[%s] Fetching MAL...", a.mangaUpdater.Prefix)
+	log.Printf("[%s] Fetching %s...", u.Prefix, tgt.Name())
 
-	tgtList, err := a.mal.GetUserMangaList(ctx)
+	tgts, err := tgt.GetList(ctx)
 	if err != nil {
-		return fmt.Errorf("error getting user anime list from mal: %w", err)
+		return err
 	}
 
-	srcs := newSourcesFromMangas(newMangasFromMediaListGroups(srcList))
-	tgts := newTargetsFromMangas(newMangasFromMalUserMangas(tgtList))
-
-	log.Printf("[%s] Got %d from AniList", a.mangaUpdater.Prefix, len(srcs))
-	log.Printf("[%s] Got %d from Mal", a.mangaUpdater.Prefix, len(tgts))
+	log.Printf("[%s] Got %d from %s", u.Prefix, len(srcs), src.Name())
+	log.Printf("[%s] Got %d from %s", u.Prefix, len(tgts), tgt.Name())
 
-	a.mangaUpdater.Update(ctx, srcs, tgts)
-	a.mangaUpdater.Statistics.Print(a.mangaUpdater.Prefix)
+	u.Update(ctx, newSourcesFromEntries(srcs), newTargetsFromEntries(tgts))
+	u.Statistics.Print(u.Prefix)
 
 	return nil
 }
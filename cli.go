@@ -0,0 +1,608 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: anilist-mal-sync [command] [flags]
+
+Commands:
+  sync     sync the lists, the default when no command is given
+  diff     show what sync would change without updating anything
+  login    authorize the program on a site: login anilist|myanimelist|kitsu|shikimori
+  logout   remove the saved token of a site: logout anilist|myanimelist|kitsu|shikimori
+  status   show the saved tokens and the authorized accounts
+  orphans  list MAL entries that no AniList entry is matched with
+  export   write the AniList list as a MAL XML import file
+  map      manage the manual mappings: map list|add|skip|remove
+
+Run "anilist-mal-sync <command> -h" for the flags of a command.
+`
+
+// runCommand runs the command given by the arguments, without a command the sync is run
+// so the flags of the previous versions keep working.
+func runCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runSync(ctx, args)
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "sync":
+		return runSync(ctx, args)
+	case "diff":
+		return runDiff(ctx, args)
+	case "login":
+		return runLogin(ctx, args)
+	case "logout":
+		return runLogout(ctx, args)
+	case "status":
+		return runStatus(ctx, args)
+	case "orphans":
+		return runOrphans(ctx, args)
+	case "export":
+		return runExport(ctx, args)
+	case "map":
+		return runMap(args)
+	case "help":
+		fmt.Print(usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command: %s", cmd)
+	}
+}
+
+// commonFlags are the flags shared by all commands.
+type commonFlags struct {
+	ConfigFile string
+	Verbose    bool
+}
+
+// loadConfig loads the config file, the HTTP clients log in verbose mode too.
+func (f *commonFlags) loadConfig() (Config, error) {
+	config, err := loadConfigFromFile(f.ConfigFile)
+	if err != nil {
+		return Config{}, err
+	}
+	config.HTTP.Verbose = f.Verbose
+	return config, nil
+}
+
+// newFlagSet creates the flag set of the command with the flags shared by all commands.
+func newFlagSet(name string) (*flag.FlagSet, *commonFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	common := &commonFlags{}
+	fs.StringVar(&common.ConfigFile, "c", "config.yaml", "path to config file")
+	fs.BoolVar(&common.Verbose, "verbose", false, "enable verbose logging")
+	return fs, common
+}
+
+// addSelectionFlags adds the flags that select what is compared, shared by sync and diff.
+func addSelectionFlags(fs *flag.FlagSet, opts *Options, ignores *ignoreFlag) *string {
+	fs.BoolVar(&opts.Force, "f", opts.Force, "force sync all animes")
+	fs.BoolVar(&opts.Manga, "manga", opts.Manga, "sync manga instead of anime")
+	fs.BoolVar(&opts.All, "all", opts.All, "sync all animes and mangas")
+	fs.StringVar(&opts.ReportPath, "report", opts.ReportPath, "write a report of processed entries to the file, CSV if it ends with .csv, JSON otherwise")
+	fs.IntVar(&opts.Workers, "workers", opts.Workers, "number of entries processed concurrently")
+	fs.Var(ignores, "ignore", "ignore an entry: anilist:<id>, mal:<id>, title:<title> or regex:<pattern>, can be repeated")
+	return fs.String("direction", string(opts.Direction), "sync direction: anilist-to-mal, mal-to-anilist or both")
+}
+
+func runSync(ctx context.Context, args []string) error {
+	opts := DefaultOptions()
+	var ignores ignoreFlag
+
+	fs, common := newFlagSet("sync")
+	direction := addSelectionFlags(fs, &opts, &ignores)
+	fs.BoolVar(&opts.DryRun, "d", false, "dry run without updating the target site")
+	fs.BoolVar(&opts.Watch, "watch", false, "keep running and sync on a schedule")
+	fs.DurationVar(&opts.Interval, "interval", opts.Interval, "interval between syncs in watch mode")
+	fs.BoolVar(&opts.Favourites, "favourites", false, "sync favourites instead of lists")
+	fs.BoolVar(&opts.Interactive, "interactive", false, "confirm matches found by title and remember the choices")
+	showState := fs.Bool("state", false, "print the sync state and exit")
+	resetState := fs.Bool("reset-state", false, "reset the sync state and exit")
+	_ = fs.Parse(args)
+	opts.Verbose = common.Verbose
+
+	opts.Direction = SyncDirection(*direction)
+	opts.Ignore = ignores.IgnoreConfig
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	config, err := common.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if *showState {
+		state, err := readStateFile(config.StateFilePath)
+		if err != nil {
+			return fmt.Errorf("read state: %w", err)
+		}
+		state.Print(os.Stdout)
+		return nil
+	}
+
+	if *resetState {
+		if err := resetStateFile(config.StateFilePath); err != nil {
+			return fmt.Errorf("reset state: %w", err)
+		}
+		fmt.Printf("State reset: %s\n", config.StateFilePath)
+		return nil
+	}
+
+	return runApp(ctx, config, opts)
+}
+
+func runDiff(ctx context.Context, args []string) error {
+	opts := DefaultOptions()
+	var ignores ignoreFlag
+
+	fs, common := newFlagSet("diff")
+	direction := addSelectionFlags(fs, &opts, &ignores)
+	_ = fs.Parse(args)
+	opts.Verbose = common.Verbose
+
+	opts.Direction = SyncDirection(*direction)
+	opts.Ignore = ignores.IgnoreConfig
+	opts.DryRun = true
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	config, err := common.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	return runApp(ctx, config, opts)
+}
+
+func runOrphans(ctx context.Context, args []string) error {
+	opts := DefaultOptions()
+	var ignores ignoreFlag
+
+	fs, common := newFlagSet("orphans")
+	fs.BoolVar(&opts.Manga, "manga", false, "check manga instead of anime")
+	fs.BoolVar(&opts.All, "all", false, "check all animes and mangas")
+	fs.IntVar(&opts.Workers, "workers", opts.Workers, "number of entries processed concurrently")
+	fs.Var(&ignores, "ignore", "ignore an entry: anilist:<id>, mal:<id>, title:<title> or regex:<pattern>, can be repeated")
+	fs.StringVar(&opts.Orphans, "format", "table", "output format: table or json")
+	_ = fs.Parse(args)
+	opts.Verbose = common.Verbose
+
+	opts.Ignore = ignores.IgnoreConfig
+	opts.DryRun = true
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	config, err := common.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	return runApp(ctx, config, opts)
+}
+
+func runExport(ctx context.Context, args []string) error {
+	var ignores ignoreFlag
+
+	fs, common := newFlagSet("export")
+	format := fs.String("format", ExportFormatMalXML, "output format: mal-xml")
+	manga := fs.Bool("manga", false, "export manga instead of anime")
+	output := fs.String("o", "", "write the export to the file instead of stdout")
+	unresolvedPath := fs.String("unresolved", "", "write the unresolved entries to the file instead of stderr")
+	workers := fs.Int("workers", DefaultOptions().Workers, "number of entries processed concurrently")
+	fs.Var(&ignores, "ignore", "ignore an entry: anilist:<id>, mal:<id>, title:<title> or regex:<pattern>, can be repeated")
+	_ = fs.Parse(args)
+
+	if *format != ExportFormatMalXML {
+		return fmt.Errorf("unknown export format: %s, expected mal-xml", *format)
+	}
+	if *workers < 1 {
+		return fmt.Errorf("workers must be positive, got %d", *workers)
+	}
+
+	config, err := common.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	saved, err := readMappingsFile(config.MappingsFilePath)
+	if err != nil {
+		return fmt.Errorf("error reading mappings file: %w", err)
+	}
+
+	prefix, mappings, ignoreRules := "Anime", NewMappings(defaultAnimeMappings, saved.Anime, config.Mappings.Anime), config.Ignore.Anime
+	if *manga {
+		prefix, mappings, ignoreRules = "Manga", NewMappings(saved.Manga, config.Mappings.Manga), config.Ignore.Manga
+	}
+
+	ignoreMatcher, err := NewIgnoreRules(ignoreRules, ignores.IgnoreConfig)
+	if err != nil {
+		return fmt.Errorf("error creating ignore rules: %w", err)
+	}
+
+	// MAL is only queried for the entries without MAL ID, by title
+	clients, err := newTrackerClients(ctx, config, TrackerAnilist, TrackerMyAnimeList)
+	if err != nil {
+		return err
+	}
+
+	src, mangaSrc := clients.trackers(config, TrackerAnilist)
+	tgt, mangaTgt := clients.trackers(config, TrackerMyAnimeList)
+	if *manga {
+		src, tgt = mangaSrc, mangaTgt
+	}
+
+	log.Printf("[%s] Fetching %s...", prefix, src.Name())
+
+	entries, err := src.GetList(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[%s] Got %d from %s", prefix, len(entries), src.Name())
+
+	u := newUpdater(prefix, tgt, mappings, ignoreMatcher)
+	u.SourceTracker, u.TargetTracker = TrackerAnilist, TrackerMyAnimeList
+	u.Workers = *workers
+	u.Verbose = common.Verbose
+
+	export := NewExport()
+	u.Export(ctx, newSourcesFromEntries(entries), export)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := writeOutput(*output, func(w io.Writer) error { return export.WriteMalXML(w, *manga) }); err != nil {
+		return fmt.Errorf("error writing export: %w", err)
+	}
+
+	log.Printf("[%s] Exported %d entries, %d unresolved", prefix, export.Len(), len(export.Unresolved))
+
+	if len(export.Unresolved) == 0 {
+		return nil
+	}
+
+	if *unresolvedPath == "" {
+		return export.WriteUnresolved(os.Stderr)
+	}
+	if err := writeOutput(*unresolvedPath, export.WriteUnresolved); err != nil {
+		return fmt.Errorf("error writing unresolved entries: %w", err)
+	}
+	return nil
+}
+
+// writeOutput writes to the file or, with an empty path, to stdout.
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "" {
+		return write(os.Stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runApp(ctx context.Context, config Config, opts Options) error {
+	app, err := NewApp(ctx, config, opts)
+	if err != nil {
+		return fmt.Errorf("create app: %w", err)
+	}
+
+	if err := app.Run(ctx); err != nil {
+		return fmt.Errorf("run app: %w", err)
+	}
+
+	return nil
+}
+
+// siteOAuth loads the token of the site without starting the authorization flow.
+func siteOAuth(ctx context.Context, config Config, site string) (*OAuth, error) {
+	switch site {
+	case TrackerAnilist:
+		return newAnilistOAuth(ctx, config)
+	case TrackerMyAnimeList, "mal":
+		return newMyAnimeListOAuth(ctx, config)
+	case TrackerKitsu:
+		return newKitsuOAuth(ctx, config)
+	case TrackerShikimori:
+		return newShikimoriOAuth(ctx, config)
+	default:
+		return nil, fmt.Errorf("unknown site: %s, expected anilist, myanimelist, kitsu or shikimori", site)
+	}
+}
+
+// parseSiteArgs parses the flags of login and logout and returns the loaded config and site.
+func parseSiteArgs(name string, args []string) (Config, string, error) {
+	fs, common := newFlagSet(name)
+	headless := fs.Bool("headless", false, "paste the redirect URL or code instead of starting the callback server")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: anilist-mal-sync %s [flags] anilist|myanimelist|kitsu|shikimori\n", name)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return Config{}, "", errors.New("expected one site")
+	}
+
+	config, err := common.loadConfig()
+	if err != nil {
+		return Config{}, "", err
+	}
+	config.OAuth.Headless = config.OAuth.Headless || *headless
+
+	return config, fs.Arg(0), nil
+}
+
+func runLogin(ctx context.Context, args []string) error {
+	config, site, err := parseSiteArgs("login", args)
+	if err != nil {
+		return err
+	}
+
+	oauth, err := siteOAuth(ctx, config, site)
+	if err != nil {
+		return err
+	}
+
+	if site == TrackerKitsu {
+		err = oauth.PasswordToken(ctx, config.Kitsu.Username, config.Kitsu.Password)
+	} else {
+		err = authorize(ctx, oauth, config.OAuth)
+	}
+	if err != nil {
+		return err
+	}
+	if oauth.NeedInit() {
+		return fmt.Errorf("login to %s was not completed", site)
+	}
+
+	fmt.Printf("Logged in to %s\n", site)
+	return nil
+}
+
+func runLogout(ctx context.Context, args []string) error {
+	config, site, err := parseSiteArgs("logout", args)
+	if err != nil {
+		return err
+	}
+
+	oauth, err := siteOAuth(ctx, config, site)
+	if err != nil {
+		return err
+	}
+
+	if oauth.NeedInit() {
+		fmt.Printf("Not logged in to %s\n", site)
+		return nil
+	}
+
+	if err := oauth.DeleteToken(); err != nil {
+		return fmt.Errorf("error deleting token: %w", err)
+	}
+
+	fmt.Printf("Logged out from %s\n", site)
+	return nil
+}
+
+func runStatus(ctx context.Context, args []string) error {
+	fs, common := newFlagSet("status")
+	showState := fs.Bool("state", false, "also print the sync state")
+	_ = fs.Parse(args)
+
+	config, err := common.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SITE\tTOKEN\tEXPIRES\tACCOUNT")
+	sites := []string{TrackerAnilist, TrackerMyAnimeList}
+	if config.Source == TrackerKitsu || config.Target == TrackerKitsu || config.Kitsu.Username != "" {
+		sites = append(sites, TrackerKitsu)
+	}
+	if config.Source == TrackerShikimori || config.Target == TrackerShikimori || config.Shikimori.ClientID != "" {
+		sites = append(sites, TrackerShikimori)
+	}
+
+	for _, site := range sites {
+		oauth, err := siteOAuth(ctx, config, site)
+		if err != nil {
+			return err
+		}
+
+		if oauth.NeedInit() {
+			fmt.Fprintf(w, "%s\tmissing\t-\t-\n", site)
+			continue
+		}
+
+		expires := "-"
+		if expiry := oauth.Expiry(); !expiry.IsZero() {
+			expires = expiry.Local().Format(time.DateTime)
+		}
+
+		account, err := accountName(ctx, config, site, oauth)
+		if err != nil {
+			account = fmt.Sprintf("error: %v", err)
+		}
+
+		fmt.Fprintf(w, "%s\tpresent\t%s\t%s\n", site, expires, account)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if *showState {
+		state, err := readStateFile(config.StateFilePath)
+		if err != nil {
+			return fmt.Errorf("read state: %w", err)
+		}
+		fmt.Println()
+		state.Print(os.Stdout)
+	}
+
+	return nil
+}
+
+// accountName asks the site for the name of the authorized user.
+func accountName(ctx context.Context, config Config, site string, oauth *OAuth) (string, error) {
+	switch site {
+	case TrackerAnilist:
+		c, err := NewAnilistClient(ctx, oauth, config.Anilist, config.HTTP)
+		if err != nil {
+			return "", err
+		}
+		return c.GetViewerName(ctx)
+	case TrackerKitsu:
+		c, err := NewKitsuClient(ctx, oauth, config.Kitsu, config.HTTP)
+		if err != nil {
+			return "", err
+		}
+		return c.GetUserName(ctx)
+	case TrackerShikimori:
+		c, err := NewShikimoriClient(ctx, oauth, config.Shikimori, config.HTTP)
+		if err != nil {
+			return "", err
+		}
+		return c.GetUserName(ctx)
+	default:
+		c, err := NewMyAnimeListClient(ctx, oauth, config.MyAnimeList, config.HTTP)
+		if err != nil {
+			return "", err
+		}
+		return c.GetUserName(ctx)
+	}
+}
+
+const mapUsage = `Usage: anilist-mal-sync map [flags] <action>
+
+Actions:
+  list                          print the saved mappings
+  add <anilist-id> <mal-id>     map the AniList entry to the MAL entry
+  skip <anilist-id>             never sync the AniList entry
+  remove <anilist-id>           remove the mapping of the AniList entry
+
+Flags:
+`
+
+func runMap(args []string) error {
+	fs, common := newFlagSet("map")
+	manga := fs.Bool("manga", false, "use the manga mappings instead of anime")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), mapUsage)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("expected an action")
+	}
+
+	config, err := common.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	mappings, err := readMappingsFile(config.MappingsFilePath)
+	if err != nil {
+		return fmt.Errorf("error reading mappings file: %w", err)
+	}
+
+	prefix := "Anime"
+	if *manga {
+		prefix = "Manga"
+	}
+
+	action, ids := fs.Arg(0), fs.Args()[1:]
+	switch action {
+	case "list":
+		printMappings(mappings)
+		return nil
+	case "add":
+		if len(ids) != 2 {
+			return errors.New("expected an AniList ID and a MAL ID")
+		}
+		anilistID, err := strconv.Atoi(ids[0])
+		if err != nil {
+			return fmt.Errorf("invalid anilist id: %w", err)
+		}
+		malID, err := strconv.Atoi(ids[1])
+		if err != nil {
+			return fmt.Errorf("invalid mal id: %w", err)
+		}
+		mappings.Add(prefix, Mapping{AnilistID: anilistID, MalID: malID})
+	case "skip":
+		if len(ids) != 1 {
+			return errors.New("expected an AniList ID")
+		}
+		anilistID, err := strconv.Atoi(ids[0])
+		if err != nil {
+			return fmt.Errorf("invalid anilist id: %w", err)
+		}
+		mappings.Add(prefix, Mapping{AnilistID: anilistID, Skip: true})
+	case "remove":
+		if len(ids) != 1 {
+			return errors.New("expected an AniList ID")
+		}
+		anilistID, err := strconv.Atoi(ids[0])
+		if err != nil {
+			return fmt.Errorf("invalid anilist id: %w", err)
+		}
+		if !mappings.Remove(prefix, anilistID) {
+			return fmt.Errorf("no %s mapping for anilist id %d", strings.ToLower(prefix), anilistID)
+		}
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown action: %s", action)
+	}
+
+	if err := writeMappingsFile(config.MappingsFilePath, mappings); err != nil {
+		return fmt.Errorf("error writing mappings file: %w", err)
+	}
+
+	fmt.Printf("Mappings saved to %s\n", config.MappingsFilePath)
+	return nil
+}
+
+func printMappings(mappings *MappingsConfig) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tANILIST ID\tTITLE\tMAL ID")
+	for _, l := range []struct {
+		kind     string
+		mappings []Mapping
+	}{{"anime", mappings.Anime}, {"manga", mappings.Manga}} {
+		for _, m := range l.mappings {
+			target := strconv.Itoa(m.MalID)
+			if m.Skip {
+				target = "skip"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", l.kind, m.AnilistID, m.Title, target)
+		}
+	}
+	_ = w.Flush()
+}
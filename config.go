@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v2"
 )
@@ -9,6 +11,8 @@ import (
 type OAuthConfig struct {
 	Port        string `yaml:"port"`
 	RedirectURI string `yaml:"redirect_uri"`
+	// Headless reads the redirect URL or code from stdin instead of starting the callback server.
+	Headless bool `yaml:"headless"`
 }
 
 type SiteConfig struct {
@@ -17,13 +21,49 @@ type SiteConfig struct {
 	AuthURL      string `yaml:"auth_url"`
 	TokenURL     string `yaml:"token_url"`
 	Username     string `yaml:"username"`
+	// RedirectURI overrides oauth.redirect_uri for the site.
+	RedirectURI string `yaml:"redirect_uri"`
+	// APIURL overrides the API endpoint, e.g. for a caching proxy or a fake server.
+	APIURL string `yaml:"api_url"`
+	// Password is used by the sites with the password grant, e.g. Kitsu.
+	Password string `yaml:"password"`
+}
+
+func (c SiteConfig) redirectURI(oauth OAuthConfig) string {
+	if c.RedirectURI != "" {
+		return c.RedirectURI
+	}
+	return oauth.RedirectURI
+}
+
+type FavouritesConfig struct {
+	Characters bool `yaml:"characters"`
+	// Writer is the name of the favourites writer, see favouritesWriters.
+	Writer   string `yaml:"writer"`
+	FilePath string `yaml:"file_path"`
 }
 
 type Config struct {
-	OAuth         OAuthConfig `yaml:"oauth"`
-	Anilist       SiteConfig  `yaml:"anilist"`
-	MyAnimeList   SiteConfig  `yaml:"myanimelist"`
-	TokenFilePath string      `yaml:"token_file_path"`
+	OAuth       OAuthConfig `yaml:"oauth"`
+	Anilist     SiteConfig  `yaml:"anilist"`
+	MyAnimeList SiteConfig  `yaml:"myanimelist"`
+	Kitsu       SiteConfig  `yaml:"kitsu"`
+	Shikimori   SiteConfig  `yaml:"shikimori"`
+	// Source and Target are the trackers synced: anilist, myanimelist, kitsu or shikimori.
+	Source        string     `yaml:"source"`
+	Target        string     `yaml:"target"`
+	HTTP          HTTPConfig `yaml:"http"`
+	TokenFilePath string     `yaml:"token_file_path"`
+	StateFilePath string     `yaml:"state_file_path"`
+	// MappingsFilePath stores the matches chosen in interactive mode.
+	MappingsFilePath string             `yaml:"mappings_file_path"`
+	Mappings         MappingsConfig     `yaml:"mappings"`
+	Ignore           IgnoresConfig      `yaml:"ignore"`
+	ScoreRounding    ScoreRounding      `yaml:"score_rounding"`
+	Favourites       FavouritesConfig   `yaml:"favourites"`
+	CustomLists      CustomListsConfig  `yaml:"custom_lists"`
+	ExtraFields      ExtraFieldsConfig  `yaml:"extra_fields"`
+	SyncPolicy       SyncPoliciesConfig `yaml:"sync_policy"`
 }
 
 func loadConfigFromFile(filename string) (Config, error) {
@@ -50,9 +90,57 @@ func loadConfigFromFile(filename string) (Config, error) {
 		cfg.MyAnimeList.ClientSecret = clientSecret
 	}
 
+	if clientSecret := os.Getenv("CLIENT_SECRET_SHIKIMORI"); clientSecret != "" {
+		cfg.Shikimori.ClientSecret = clientSecret
+	}
+
+	if password := os.Getenv("KITSU_PASSWORD"); password != "" {
+		cfg.Kitsu.Password = password
+	}
+
+	if cfg.Source == "" {
+		cfg.Source = TrackerAnilist
+	}
+
+	if cfg.Target == "" {
+		cfg.Target = TrackerMyAnimeList
+	}
+
+	if !validTracker(cfg.Source) {
+		return Config{}, fmt.Errorf("unknown source: %s", cfg.Source)
+	}
+
+	if !validTracker(cfg.Target) {
+		return Config{}, fmt.Errorf("unknown target: %s", cfg.Target)
+	}
+
+	if cfg.Source == cfg.Target {
+		return Config{}, fmt.Errorf("source and target are the same: %s", cfg.Source)
+	}
+
+	if err := cfg.ExtraFields.Validate(); err != nil {
+		return Config{}, err
+	}
+
 	if cfg.TokenFilePath == "" {
 		cfg.TokenFilePath = os.ExpandEnv("$HOME/.config/anilist-mal-sync/token.json")
 	}
 
+	if cfg.StateFilePath == "" {
+		cfg.StateFilePath = filepath.Join(filepath.Dir(cfg.TokenFilePath), "state.json")
+	}
+
+	if cfg.MappingsFilePath == "" {
+		cfg.MappingsFilePath = filepath.Join(filepath.Dir(cfg.TokenFilePath), "mappings.json")
+	}
+
+	if cfg.Favourites.Writer == "" {
+		cfg.Favourites.Writer = favouritesWriterFile
+	}
+
+	if cfg.Favourites.FilePath == "" {
+		cfg.Favourites.FilePath = filepath.Join(filepath.Dir(cfg.TokenFilePath), "favourites.json")
+	}
+
 	return cfg, nil
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rl404/verniy"
+)
+
+type CustomListsConfig struct {
+	Anime CustomListTags `yaml:"anime"`
+	Manga CustomListTags `yaml:"manga"`
+}
+
+// CustomListTags maps AniList custom lists to MAL tags.
+type CustomListTags struct {
+	// All exports every custom list as a tag named after the list.
+	All bool `yaml:"all"`
+	// Lists maps a custom list name to the MAL tag, an empty tag skips the list.
+	Lists map[string]string `yaml:"lists"`
+}
+
+// Tag returns the MAL tag for the custom list.
+func (c CustomListTags) Tag(list string) (string, bool) {
+	if tag, ok := c.Lists[list]; ok {
+		return sanitizeMalTag(tag), tag != ""
+	}
+	if c.All {
+		return sanitizeMalTag(list), true
+	}
+	return "", false
+}
+
+// Tags returns the MAL tags for the custom lists, sorted and without duplicates.
+func (c CustomListTags) Tags(lists []string) []string {
+	var tags []string
+	for _, list := range lists {
+		if tag, ok := c.Tag(list); ok {
+			tags = appendTag(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ManagedTags returns the MAL tags the custom lists of the groups and the configured lists are exported as,
+// nil when no custom list is exported.
+func (c CustomListTags) ManagedTags(groups []verniy.MediaListGroup) []string {
+	if !c.All && len(c.Lists) == 0 {
+		return nil
+	}
+
+	tags := make([]string, 0, len(c.Lists))
+	for _, tag := range c.Lists {
+		if tag != "" {
+			tags = appendTag(tags, sanitizeMalTag(tag))
+		}
+	}
+	for _, group := range groups {
+		if group.IsCustomList == nil || !*group.IsCustomList || group.Name == nil {
+			continue
+		}
+		if tag, ok := c.Tag(*group.Name); ok {
+			tags = appendTag(tags, tag)
+		}
+	}
+	return tags
+}
+
+// customListsByMediaID collects the names of the custom lists every media belongs to.
+func customListsByMediaID(groups []verniy.MediaListGroup) map[int][]string {
+	res := make(map[int][]string)
+	for _, group := range groups {
+		if group.IsCustomList == nil || !*group.IsCustomList || group.Name == nil {
+			continue
+		}
+
+		for _, entry := range group.Entries {
+			if entry.Media == nil {
+				continue
+			}
+			res[entry.Media.ID] = append(res[entry.Media.ID], *group.Name)
+		}
+	}
+	return res
+}
+
+// uniqueMediaListEntries returns every list entry once, AniList repeats the entries of custom lists
+// in their own groups. An entry is taken from its status group, entries only in custom lists
+// are taken from the first custom list by name.
+func uniqueMediaListEntries(groups []verniy.MediaListGroup) []verniy.MediaList {
+	custom := make([]verniy.MediaListGroup, 0, len(groups))
+	ordered := make([]verniy.MediaListGroup, 0, len(groups))
+	for _, group := range groups {
+		if group.IsCustomList != nil && *group.IsCustomList {
+			custom = append(custom, group)
+			continue
+		}
+		ordered = append(ordered, group)
+	}
+
+	sort.SliceStable(custom, func(i, j int) bool {
+		return groupName(custom[i]) < groupName(custom[j])
+	})
+	ordered = append(ordered, custom...)
+
+	seen := make(map[int]struct{})
+	var res []verniy.MediaList
+	for _, group := range ordered {
+		for _, entry := range group.Entries {
+			if entry.Media != nil {
+				if _, ok := seen[entry.Media.ID]; ok {
+					continue
+				}
+				seen[entry.Media.ID] = struct{}{}
+			}
+			res = append(res, entry)
+		}
+	}
+	return res
+}
+
+func groupName(group verniy.MediaListGroup) string {
+	if group.Name == nil {
+		return ""
+	}
+	return *group.Name
+}
+
+// appendTag adds the tag keeping the tags sorted and unique.
+func appendTag(tags []string, tag string) []string {
+	i := sort.SearchStrings(tags, tag)
+	if i < len(tags) && tags[i] == tag {
+		return tags
+	}
+	tags = append(tags, "")
+	copy(tags[i+1:], tags[i:])
+	tags[i] = tag
+	return tags
+}
+
+// sanitizeMalTag removes commas, MAL sends the tags as a comma separated list.
+func sanitizeMalTag(tag string) string {
+	return strings.TrimSpace(strings.ReplaceAll(tag, ",", " "))
+}
+
+// mergeMalTags returns the MAL tags with the managed ones replaced by the tags, sorted and without duplicates.
+func mergeMalTags(current, tags, managed []string) []string {
+	res := make([]string, 0, len(current)+len(tags))
+	for _, tag := range current {
+		if !slices.Contains(managed, tag) {
+			res = appendTag(res, tag)
+		}
+	}
+	for _, tag := range tags {
+		res = appendTag(res, tag)
+	}
+	return res
+}
+
+// malTagsWithoutPrivate returns the MAL tags sorted, without the private tag.
+func malTagsWithoutPrivate(tags []string) []string {
+	res := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !strings.EqualFold(tag, malPrivateTag) {
+			res = appendTag(res, tag)
+		}
+	}
+	return res
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/rl404/verniy"
+)
+
+func testMediaList(id, progress int, title string) verniy.MediaList {
+	status := verniy.MediaListStatusCurrent
+	return verniy.MediaList{
+		Status:   &status,
+		Progress: &progress,
+		Media: &verniy.Media{
+			ID:    id,
+			Title: &verniy.MediaTitle{Romaji: &title},
+		},
+	}
+}
+
+func testMediaListGroup(name string, custom bool, entries ...verniy.MediaList) verniy.MediaListGroup {
+	return verniy.MediaListGroup{Name: &name, IsCustomList: &custom, Entries: entries}
+}
+
+// testMediaListGroups has an entry in a status group and a custom list, and one only in two custom lists
+// with the later list by name first.
+func testMediaListGroups() []verniy.MediaListGroup {
+	return []verniy.MediaListGroup{
+		testMediaListGroup("Watching", false, testMediaList(1, 5, "Status and custom")),
+		testMediaListGroup("Seasonal", true, testMediaList(1, 1, "Status and custom"), testMediaList(2, 2, "Only custom")),
+		testMediaListGroup("Favorites", true, testMediaList(2, 3, "Only custom")),
+	}
+}
+
+func TestUniqueMediaListEntries(t *testing.T) {
+	entries := uniqueMediaListEntries(testMediaListGroups())
+
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+
+	progress := make(map[int]int)
+	for _, e := range entries {
+		progress[e.Media.ID] = *e.Progress
+	}
+
+	if progress[1] != 5 {
+		t.Errorf("entry in a status group: got progress %d, want 5 from the status group", progress[1])
+	}
+	if progress[2] != 3 {
+		t.Errorf("entry only in custom lists: got progress %d, want 3 from the first list by name", progress[2])
+	}
+}
+
+func TestNewFromMediaListGroupsUnique(t *testing.T) {
+	groups := testMediaListGroups()
+	lists := CustomListTags{All: true}
+
+	animes := newAnimesFromMediaListGroups(groups, ScoreConverter{}, lists, ExtraFields{})
+	mangas := newMangasFromMediaListGroups(groups, ScoreConverter{}, lists, ExtraFields{})
+
+	animeIDs := make([]int, 0, len(animes))
+	for _, a := range animes {
+		animeIDs = append(animeIDs, a.IDAnilist)
+	}
+	mangaIDs := make([]int, 0, len(mangas))
+	for _, m := range mangas {
+		mangaIDs = append(mangaIDs, m.IDAnilist)
+	}
+
+	slices.Sort(animeIDs)
+	slices.Sort(mangaIDs)
+	if want := []int{1, 2}; !slices.Equal(animeIDs, want) || !slices.Equal(mangaIDs, want) {
+		t.Fatalf("got anime %v and manga %v, want %v", animeIDs, mangaIDs, want)
+	}
+
+	// the tags still come from every custom list of the entry
+	for _, a := range animes {
+		if a.IDAnilist == 2 && !slices.Equal(a.Tags, []string{"Favorites", "Seasonal"}) {
+			t.Errorf("got anime tags %v, want both custom lists", a.Tags)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// ExportFormatMalXML is the XML format of the MAL list export, MAL imports it back from its import page.
+const ExportFormatMalXML = "mal-xml"
+
+// MAL export types of the myinfo section.
+const (
+	malExportTypeAnime = 1
+	malExportTypeManga = 2
+)
+
+// UnresolvedEntry is a source entry left out of the export because no MAL entry was found for it.
+type UnresolvedEntry struct {
+	Type      string
+	AnilistID int
+	Title     string
+	Reason    string
+}
+
+// Export collects the sources with their MAL IDs in the MAL XML export format.
+type Export struct {
+	mu         sync.Mutex
+	anime      []malExportAnime
+	manga      []malExportManga
+	Unresolved []UnresolvedEntry
+}
+
+func NewExport() *Export {
+	return &Export{}
+}
+
+// Export resolves the MAL IDs of the sources like sync does, by mappings, IDs and title search,
+// and adds them to the export. Nothing is updated.
+func (u *Updater) Export(ctx context.Context, srcs []Source, e *Export) {
+	u.forEach(ctx, srcs, func(src Source) {
+		if rule, ok := u.Ignores.Match(src); ok {
+			u.debugf("[%s] Ignoring %s by %s", u.Prefix, src.GetTitle(), rule)
+			return
+		}
+
+		if m, ok := u.Mappings.Find(src); ok && m.Skip {
+			u.debugf("[%s] Ignoring %s by mapping", u.Prefix, src.GetTitle())
+			return
+		}
+
+		tgtID, title := u.targetID(src), src.GetTitle()
+		if tgtID <= 0 {
+			tgt, err := u.findTarget(ctx, src)
+			if errors.Is(err, errSkippedByUser) {
+				return
+			}
+			if err != nil {
+				log.Printf("[%s] Unresolved %s: %v", u.Prefix, src.GetTitle(), err)
+				e.addUnresolved(u.Prefix, src, err.Error())
+				return
+			}
+			tgtID, title = tgt.GetTargetID(), targetTitle(tgt)
+		}
+
+		if err := e.add(src, tgtID, title); err != nil {
+			e.addUnresolved(u.Prefix, src, err.Error())
+		}
+	})
+}
+
+// Len returns the number of exported entries.
+func (e *Export) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.anime) + len(e.manga)
+}
+
+func (e *Export) add(src Source, id TargetID, title string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch s := src.(type) {
+	case Anime:
+		a, err := newMalExportAnime(s, id, title)
+		if err != nil {
+			return err
+		}
+		e.anime = append(e.anime, a)
+	case Manga:
+		m, err := newMalExportManga(s, id, title)
+		if err != nil {
+			return err
+		}
+		e.manga = append(e.manga, m)
+	default:
+		return fmt.Errorf("unknown source type: %T", src)
+	}
+	return nil
+}
+
+func (e *Export) addUnresolved(prefix string, src Source, reason string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Unresolved = append(e.Unresolved, UnresolvedEntry{
+		Type:      prefix,
+		AnilistID: max(src.GetAnilistID(), 0),
+		Title:     src.GetTitle(),
+		Reason:    reason,
+	})
+}
+
+// WriteMalXML writes the anime, or with manga the manga, in the MAL XML export format.
+// Every entry has update_on_import set, so the import overwrites the entries already on MAL.
+func (e *Export) WriteMalXML(w io.Writer, manga bool) error {
+	doc := malExport{MyInfo: malExportInfo{ExportType: malExportTypeAnime}}
+	if manga {
+		doc.MyInfo.ExportType = malExportTypeManga
+		doc.Manga = e.manga
+		sort.Slice(doc.Manga, func(i, j int) bool { return doc.Manga[i].ID < doc.Manga[j].ID })
+	} else {
+		doc.Anime = e.anime
+		sort.Slice(doc.Anime, func(i, j int) bool { return doc.Anime[i].ID < doc.Anime[j].ID })
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteUnresolved writes the entries left out of the export as a table.
+func (e *Export) WriteUnresolved(w io.Writer) error {
+	sort.Slice(e.Unresolved, func(i, j int) bool { return e.Unresolved[i].AnilistID < e.Unresolved[j].AnilistID })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tANILIST ID\tTITLE\tREASON")
+	for _, u := range e.Unresolved {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", u.Type, u.AnilistID, u.Title, u.Reason)
+	}
+	return tw.Flush()
+}
+
+type malExport struct {
+	XMLName xml.Name         `xml:"myanimelist"`
+	MyInfo  malExportInfo    `xml:"myinfo"`
+	Anime   []malExportAnime `xml:"anime"`
+	Manga   []malExportManga `xml:"manga"`
+}
+
+type malExportInfo struct {
+	ExportType int `xml:"user_export_type"`
+}
+
+// cdata keeps the text as in MAL's own exports, titles and notes may contain markup characters.
+type cdata struct {
+	Text string `xml:",cdata"`
+}
+
+type malExportAnime struct {
+	ID             int    `xml:"series_animedb_id"`
+	Title          cdata  `xml:"series_title"`
+	Type           string `xml:"series_type"`
+	Episodes       int    `xml:"series_episodes"`
+	MyID           int    `xml:"my_id"`
+	WatchedEps     int    `xml:"my_watched_episodes"`
+	StartDate      string `xml:"my_start_date"`
+	FinishDate     string `xml:"my_finish_date"`
+	Score          int    `xml:"my_score"`
+	Status         string `xml:"my_status"`
+	Comments       cdata  `xml:"my_comments"`
+	TimesWatched   int    `xml:"my_times_watched"`
+	RewatchValue   string `xml:"my_rewatch_value"`
+	Priority       string `xml:"my_priority"`
+	Tags           cdata  `xml:"my_tags"`
+	Rewatching     int    `xml:"my_rewatching"`
+	UpdateOnImport int    `xml:"update_on_import"`
+}
+
+type malExportManga struct {
+	ID             int    `xml:"manga_mangadb_id"`
+	Title          cdata  `xml:"manga_title"`
+	Volumes        int    `xml:"manga_volumes"`
+	Chapters       int    `xml:"manga_chapters"`
+	MyID           int    `xml:"my_id"`
+	ReadVolumes    int    `xml:"my_read_volumes"`
+	ReadChapters   int    `xml:"my_read_chapters"`
+	StartDate      string `xml:"my_start_date"`
+	FinishDate     string `xml:"my_finish_date"`
+	Score          int    `xml:"my_score"`
+	Status         string `xml:"my_status"`
+	Comments       cdata  `xml:"my_comments"`
+	TimesRead      int    `xml:"my_times_read"`
+	Tags           cdata  `xml:"my_tags"`
+	Priority       string `xml:"my_priority"`
+	RereadValue    string `xml:"my_reread_value"`
+	Rereading      int    `xml:"my_rereading"`
+	UpdateOnImport int    `xml:"update_on_import"`
+}
+
+func newMalExportAnime(a Anime, id TargetID, title string) (malExportAnime, error) {
+	status, err := a.Status.GetMalExportStatus()
+	if err != nil {
+		return malExportAnime{}, err
+	}
+
+	return malExportAnime{
+		ID:             int(id),
+		Title:          cdata{title},
+		Type:           malExportMediaType(a.MediaType),
+		Episodes:       a.NumEpisodes,
+		WatchedEps:     a.Progress,
+		StartDate:      malExportDate(a.StartedAt),
+		FinishDate:     malExportDate(finishDate(a.FinishedAt, a.Status == StatusCompleted || a.Status == StatusDropped)),
+		Score:          int(a.Score),
+		Status:         status,
+		Comments:       cdata{a.Notes},
+		TimesWatched:   a.NumTimesRewatched,
+		RewatchValue:   malExportValue(a.RewatchValue),
+		Priority:       malExportPriority(a.Priority),
+		Tags:           cdata{strings.Join(a.malTags(), ", ")},
+		Rewatching:     malExportBool(a.IsRewatching),
+		UpdateOnImport: 1,
+	}, nil
+}
+
+func newMalExportManga(m Manga, id TargetID, title string) (malExportManga, error) {
+	status, err := m.Status.GetMalExportStatus()
+	if err != nil {
+		return malExportManga{}, err
+	}
+
+	return malExportManga{
+		ID:             int(id),
+		Title:          cdata{title},
+		Volumes:        m.Volumes,
+		Chapters:       m.Chapters,
+		ReadVolumes:    m.ProgressVolumes,
+		ReadChapters:   m.Progress,
+		StartDate:      malExportDate(m.StartedAt),
+		FinishDate:     malExportDate(finishDate(m.FinishedAt, m.Status == MangaStatusCompleted || m.Status == MangaStatusDropped)),
+		Score:          int(m.Score),
+		Status:         status,
+		Comments:       cdata{m.Notes},
+		TimesRead:      m.NumTimesReread,
+		Tags:           cdata{strings.Join(m.malTags(), ", ")},
+		Priority:       malExportPriority(m.Priority),
+		RereadValue:    malExportValue(m.RereadValue),
+		Rereading:      malExportBool(m.IsRereading),
+		UpdateOnImport: 1,
+	}, nil
+}
+
+// GetMalExportStatus returns the status as written in the MAL XML export.
+func (s Status) GetMalExportStatus() (string, error) {
+	switch s {
+	case StatusWatching:
+		return "Watching", nil
+	case StatusCompleted:
+		return "Completed", nil
+	case StatusOnHold:
+		return "On-Hold", nil
+	case StatusDropped:
+		return "Dropped", nil
+	case StatusPlanToWatch:
+		return "Plan to Watch", nil
+	default:
+		return "", errStatusUnknown
+	}
+}
+
+// GetMalExportStatus returns the status as written in the MAL XML export.
+func (s MangaStatus) GetMalExportStatus() (string, error) {
+	switch s {
+	case MangaStatusReading:
+		return "Reading", nil
+	case MangaStatusCompleted:
+		return "Completed", nil
+	case MangaStatusOnHold:
+		return "On-Hold", nil
+	case MangaStatusDropped:
+		return "Dropped", nil
+	case MangaStatusPlanToRead:
+		return "Plan to Read", nil
+	default:
+		return "", errStatusUnknown
+	}
+}
+
+func malExportMediaType(t string) string {
+	switch t {
+	case "tv", "tv_short":
+		return "TV"
+	case "movie":
+		return "Movie"
+	case "ova", "ona":
+		return strings.ToUpper(t)
+	case "special":
+		return "Special"
+	case "music":
+		return "Music"
+	default:
+		return "Unknown"
+	}
+}
+
+// finishDate returns the date only for finished entries, the others can't have a finish date on MAL.
+func finishDate(t *time.Time, finished bool) *time.Time {
+	if !finished {
+		return nil
+	}
+	return t
+}
+
+func malExportDate(t *time.Time) string {
+	if t == nil {
+		return "0000-00-00"
+	}
+	return t.Format(time.DateOnly)
+}
+
+// malExportPriority returns the MAL priority name, low when not managed.
+// The values are MAL priorities or validated to be one by the config.
+func malExportPriority(p *int) string {
+	if p == nil {
+		return "LOW"
+	}
+
+	switch *p {
+	case 1:
+		return "MEDIUM"
+	case 2:
+		return "HIGH"
+	default:
+		return "LOW"
+	}
+}
+
+func malExportValue(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+func malExportBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
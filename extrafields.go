@@ -0,0 +1,105 @@
+package main
+
+import "fmt"
+
+// MAL ranges of the list fields AniList doesn't have.
+const (
+	malMaxPriority     = 2 // 0 low, 1 medium, 2 high
+	malMaxRewatchValue = 5 // 0 not set, 1 very low ... 5 very high
+)
+
+type ExtraFieldsConfig struct {
+	Anime ExtraFields `yaml:"anime"`
+	Manga ExtraFields `yaml:"manga"`
+}
+
+// ExtraFields derives MAL list fields AniList doesn't model. Fields without rules are left untouched on MAL.
+type ExtraFields struct {
+	Priority     ExtraFieldRule `yaml:"priority"`
+	RewatchValue ExtraFieldRule `yaml:"rewatch_value"` // reread value for manga
+}
+
+// ExtraFieldRule sets the value from the custom lists of the entry, then from the AniList value, then the default.
+type ExtraFieldRule struct {
+	// Lists maps a custom list name to the value, the highest one wins when the entry is in several lists.
+	Lists map[string]int `yaml:"lists"`
+	// FromAnilist maps the AniList value, e.g. the list entry priority, to the value.
+	// AniList values missing from the map are not used.
+	FromAnilist map[int]int `yaml:"from_anilist"`
+	Default     *int        `yaml:"default"`
+}
+
+func (c ExtraFieldsConfig) Validate() error {
+	if err := c.Anime.Validate(); err != nil {
+		return fmt.Errorf("error in anime extra fields: %w", err)
+	}
+	if err := c.Manga.Validate(); err != nil {
+		return fmt.Errorf("error in manga extra fields: %w", err)
+	}
+	return nil
+}
+
+func (f ExtraFields) Validate() error {
+	if err := f.Priority.Validate(malMaxPriority); err != nil {
+		return fmt.Errorf("error in priority: %w", err)
+	}
+	if err := f.RewatchValue.Validate(malMaxRewatchValue); err != nil {
+		return fmt.Errorf("error in rewatch value: %w", err)
+	}
+	return nil
+}
+
+// Validate checks that every value of the rule is in the MAL range, out of range values are rejected.
+func (r ExtraFieldRule) Validate(maxValue int) error {
+	valid := func(v int) bool { return v >= 0 && v <= maxValue }
+
+	for list, v := range r.Lists {
+		if !valid(v) {
+			return fmt.Errorf("value %d of list %s is out of range 0-%d", v, list, maxValue)
+		}
+	}
+	for from, v := range r.FromAnilist {
+		if !valid(v) {
+			return fmt.Errorf("value %d for anilist value %d is out of range 0-%d", v, from, maxValue)
+		}
+	}
+	if r.Default != nil && !valid(*r.Default) {
+		return fmt.Errorf("default %d is out of range 0-%d", *r.Default, maxValue)
+	}
+	return nil
+}
+
+// Value returns the value for the entry in the custom lists, nil if the field is not managed.
+func (r ExtraFieldRule) Value(lists []string, anilist *int) *int {
+	var res *int
+	for _, list := range lists {
+		if v, ok := r.Lists[list]; ok && (res == nil || v > *res) {
+			res = &v
+		}
+	}
+
+	if res == nil && anilist != nil {
+		if v, ok := r.FromAnilist[*anilist]; ok {
+			res = &v
+		}
+	}
+
+	if res == nil && r.Default != nil {
+		v := *r.Default
+		res = &v
+	}
+
+	return res
+}
+
+// sameOptionalInt reports whether both values are equal, a value missing on either side is not compared.
+func sameOptionalInt(a, b *int) bool {
+	return a == nil || b == nil || *a == *b
+}
+
+func optionalInt(v *int) int {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestExtraFieldRuleValue(t *testing.T) {
+	ptr := func(v int) *int { return &v }
+	rule := ExtraFieldRule{
+		Lists:       map[string]int{"Rewatch queue": 2, "Later": 0},
+		FromAnilist: map[int]int{0: 0, 1: 1, 5: 2},
+		Default:     ptr(0),
+	}
+
+	tests := []struct {
+		name    string
+		lists   []string
+		anilist *int
+		want    *int
+	}{
+		{name: "highest list", lists: []string{"Later", "Rewatch queue"}, anilist: ptr(1), want: ptr(2)},
+		{name: "mapped anilist value", anilist: ptr(5), want: ptr(2)},
+		{name: "unmapped anilist value", anilist: ptr(3), want: ptr(0)},
+		{name: "default", want: ptr(0)},
+	}
+
+	for _, tt := range tests {
+		got := rule.Value(tt.lists, tt.anilist)
+		if got == nil || *got != *tt.want {
+			t.Errorf("%s: got %v, want %d", tt.name, got, *tt.want)
+		}
+	}
+
+	if got := (ExtraFieldRule{FromAnilist: map[int]int{1: 1}}).Value(nil, ptr(3)); got != nil {
+		t.Errorf("unmapped value without default: got %d, want not managed", *got)
+	}
+}
+
+func TestExtraFieldRuleValidate(t *testing.T) {
+	ptr := func(v int) *int { return &v }
+
+	for name, rule := range map[string]ExtraFieldRule{
+		"list":         {Lists: map[string]int{"Rewatch queue": 3}},
+		"from anilist": {FromAnilist: map[int]int{10: 3}},
+		"negative":     {FromAnilist: map[int]int{0: -1}},
+		"default":      {Default: ptr(3)},
+	} {
+		if err := rule.Validate(malMaxPriority); err == nil {
+			t.Errorf("%s: out of range value accepted", name)
+		}
+	}
+
+	rule := ExtraFieldRule{Lists: map[string]int{"Rewatch queue": 2}, FromAnilist: map[int]int{0: 0, 5: 2}, Default: ptr(1)}
+	if err := rule.Validate(malMaxPriority); err != nil {
+		t.Errorf("valid rule rejected: %v", err)
+	}
+}
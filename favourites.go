@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+const (
+	FavouriteTypeAnime     = "anime"
+	FavouriteTypeManga     = "manga"
+	FavouriteTypeCharacter = "character"
+)
+
+// Favourite is an AniList favourite, MalID is 0 when AniList has no MAL mapping for it.
+type Favourite struct {
+	Type      string `json:"type"`
+	AnilistID int    `json:"anilist_id"`
+	MalID     int    `json:"mal_id,omitempty"`
+	Title     string `json:"title"`
+}
+
+// key identifies the favourite on the target side, characters have no MAL mapping and use the AniList ID.
+func (f Favourite) key() string {
+	if f.MalID > 0 {
+		return f.Type + ":mal:" + strconv.Itoa(f.MalID)
+	}
+	return f.Type + ":anilist:" + strconv.Itoa(f.AnilistID)
+}
+
+// FavouritesWriter reads and applies favourites on the target side.
+// The MAL API has no favourites endpoints, so the built-in writer keeps them in a file
+// and other writers can be plugged in through favouritesWriters.
+type FavouritesWriter interface {
+	GetFavourites(ctx context.Context) ([]Favourite, error)
+	AddFavourite(ctx context.Context, f Favourite) error
+	RemoveFavourite(ctx context.Context, f Favourite) error
+}
+
+const favouritesWriterFile = "file"
+
+// favouritesWriters creates the writer selected by favourites.writer in the config.
+var favouritesWriters = map[string]func(FavouritesConfig) (FavouritesWriter, error){
+	favouritesWriterFile: func(c FavouritesConfig) (FavouritesWriter, error) {
+		return newFileFavouritesWriter(c.FilePath), nil
+	},
+}
+
+func newFavouritesWriter(c FavouritesConfig) (FavouritesWriter, error) {
+	newWriter, ok := favouritesWriters[c.Writer]
+	if !ok {
+		return nil, fmt.Errorf("unknown favourites writer: %s", c.Writer)
+	}
+	return newWriter(c)
+}
+
+type FavouritesDiff struct {
+	Added      []Favourite
+	Removed    []Favourite
+	Unresolved []Favourite
+}
+
+func (d FavouritesDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// diffFavourites compares the source favourites with the target ones.
+// Anime and manga without MAL ID can't be written and are listed as unresolved.
+func diffFavourites(src, tgt []Favourite) FavouritesDiff {
+	var diff FavouritesDiff
+
+	srcKeys := make(map[string]struct{}, len(src))
+	for _, f := range src {
+		if f.MalID == 0 && f.Type != FavouriteTypeCharacter {
+			diff.Unresolved = append(diff.Unresolved, f)
+			continue
+		}
+		srcKeys[f.key()] = struct{}{}
+	}
+
+	tgtKeys := make(map[string]struct{}, len(tgt))
+	for _, f := range tgt {
+		tgtKeys[f.key()] = struct{}{}
+		if _, ok := srcKeys[f.key()]; !ok {
+			diff.Removed = append(diff.Removed, f)
+		}
+	}
+
+	for _, f := range src {
+		if _, ok := srcKeys[f.key()]; !ok {
+			continue
+		}
+		if _, ok := tgtKeys[f.key()]; !ok {
+			diff.Added = append(diff.Added, f)
+		}
+	}
+
+	return diff
+}
+
+func (d FavouritesDiff) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANGE\tTYPE\tANILIST ID\tMAL ID\tTITLE")
+	for _, group := range []struct {
+		change string
+		list   []Favourite
+	}{{"add", d.Added}, {"remove", d.Removed}, {"unresolved", d.Unresolved}} {
+		for _, f := range group.list {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", group.change, f.Type, f.AnilistID, f.MalID, f.Title)
+		}
+	}
+	tw.Flush()
+}
+
+// Apply adds and removes the favourites with the writer.
+func (d FavouritesDiff) Apply(ctx context.Context, w FavouritesWriter) error {
+	for _, f := range d.Added {
+		if err := w.AddFavourite(ctx, f); err != nil {
+			return fmt.Errorf("error adding favourite %s: %w", f.Title, err)
+		}
+	}
+	for _, f := range d.Removed {
+		if err := w.RemoveFavourite(ctx, f); err != nil {
+			return fmt.Errorf("error removing favourite %s: %w", f.Title, err)
+		}
+	}
+	return nil
+}
+
+func (d FavouritesDiff) AddToReport(r *Report) {
+	for _, f := range d.Added {
+		r.Add(ReportEntry{Type: f.Type, AnilistID: f.AnilistID, MalID: f.MalID, Title: f.Title, Action: ReportActionFavouriteAdded})
+	}
+	for _, f := range d.Removed {
+		r.Add(ReportEntry{Type: f.Type, AnilistID: f.AnilistID, MalID: f.MalID, Title: f.Title, Action: ReportActionFavouriteRemoved})
+	}
+	for _, f := range d.Unresolved {
+		r.Add(ReportEntry{Type: f.Type, AnilistID: f.AnilistID, Title: f.Title, Action: ReportActionNotFound})
+	}
+}
+
+// fileFavouritesWriter keeps the favourites in a JSON file.
+type fileFavouritesWriter struct {
+	path string
+}
+
+func newFileFavouritesWriter(path string) *fileFavouritesWriter {
+	return &fileFavouritesWriter{path: path}
+}
+
+func (w *fileFavouritesWriter) GetFavourites(_ context.Context) ([]Favourite, error) {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var favourites []Favourite
+	if err := json.Unmarshal(data, &favourites); err != nil {
+		return nil, err
+	}
+	return favourites, nil
+}
+
+func (w *fileFavouritesWriter) AddFavourite(ctx context.Context, f Favourite) error {
+	favourites, err := w.GetFavourites(ctx)
+	if err != nil {
+		return err
+	}
+	return w.write(append(favourites, f))
+}
+
+func (w *fileFavouritesWriter) RemoveFavourite(ctx context.Context, f Favourite) error {
+	favourites, err := w.GetFavourites(ctx)
+	if err != nil {
+		return err
+	}
+
+	kept := favourites[:0]
+	for _, existing := range favourites {
+		if existing.key() != f.key() {
+			kept = append(kept, existing)
+		}
+	}
+	return w.write(kept)
+}
+
+func (w *fileFavouritesWriter) write(favourites []Favourite) error {
+	sort.SliceStable(favourites, func(i, j int) bool {
+		return favourites[i].Type < favourites[j].Type
+	})
+
+	if err := createDirIfNotExists(w.path); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(favourites, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(w.path, data, 0o600)
+}
+
+// syncFavourites compares the AniList favourites with the writer ones and applies the difference.
+func (a *App) syncFavourites(ctx context.Context) error {
+	if a.anilist == nil {
+		return fmt.Errorf("favourites need anilist as the source or the target")
+	}
+
+	src, err := a.anilist.GetUserFavourites(ctx, a.config.Favourites.Characters)
+	if err != nil {
+		return fmt.Errorf("error getting anilist favourites: %w", err)
+	}
+
+	tgt, err := a.favourites.GetFavourites(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting target favourites: %w", err)
+	}
+
+	diff := diffFavourites(src, tgt)
+
+	log.Printf("[Favourites] %d on AniList, %d to add, %d to remove, %d without MAL ID",
+		len(src), len(diff.Added), len(diff.Removed), len(diff.Unresolved))
+
+	diff.Print(os.Stdout)
+
+	if a.opts.ReportPath != "" {
+		report := NewReport()
+		diff.AddToReport(report)
+		if err := report.WriteFile(a.opts.ReportPath); err != nil {
+			return fmt.Errorf("error writing report: %w", err)
+		}
+	}
+
+	if a.opts.DryRun || diff.Empty() {
+		return nil
+	}
+
+	if err := diff.Apply(ctx, a.favourites); err != nil {
+		return err
+	}
+
+	log.Printf("[Favourites] Applied %d changes", len(diff.Added)+len(diff.Removed))
+	return nil
+}
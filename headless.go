@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// anilistPinRedirectURI makes AniList show the authorization code on its own page
+// instead of redirecting to a local server.
+const anilistPinRedirectURI = "https://anilist.co/api/v2/oauth/pin"
+
+// showsCode reports whether the site shows the code to the user instead of redirecting with it.
+func showsCode(redirectURI string) bool {
+	return redirectURI == anilistPinRedirectURI || redirectURI == shikimoriOOBRedirectURI
+}
+
+// authorize runs the authorization flow of the site: the local callback server,
+// or reading the redirect URL or code from stdin in headless mode.
+func authorize(ctx context.Context, oauth *OAuth, config OAuthConfig) error {
+	if config.Headless || showsCode(oauth.Config.RedirectURL) {
+		return getTokenHeadless(ctx, oauth, stdin, os.Stdout)
+	}
+
+	getToken(ctx, oauth, config.Port)
+	return nil
+}
+
+// getTokenHeadless prints the authorization URL and exchanges the code pasted by the user.
+func getTokenHeadless(ctx context.Context, oauth *OAuth, in *bufio.Reader, out io.Writer) error {
+	fmt.Fprintf(out, "Navigate to the following URL for %s authorization:\n%s\n", oauth.siteName, oauth.GetAuthURL())
+	if showsCode(oauth.Config.RedirectURL) {
+		fmt.Fprintf(out, "Then paste the code shown by %s.\n", oauth.siteName)
+	} else {
+		fmt.Fprintln(out, "Then paste the URL you were redirected to.")
+	}
+
+	for {
+		fmt.Fprint(out, "> ")
+
+		line, err := readLine(ctx, in)
+		if err != nil {
+			return fmt.Errorf("error reading authorization code: %w", err)
+		}
+
+		code, err := parseAuthCode(line, oauth.state, showsCode(oauth.Config.RedirectURL))
+		if err != nil {
+			fmt.Fprintf(out, "Invalid input: %v\n", err)
+			continue
+		}
+
+		exchangeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err = oauth.ExchangeToken(exchangeCtx, code)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("error exchanging code for token: %w", err)
+		}
+
+		log.Printf("Token saved for %s", oauth.siteName)
+		return nil
+	}
+}
+
+// readLine reads a single line, the read is abandoned when the context is done.
+// Only one line is read per call, so nothing is read after the function returns except
+// the line of an abandoned read.
+func readLine(ctx context.Context, in *bufio.Reader) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		line, err := in.ReadString('\n')
+		if line != "" {
+			err = nil
+		}
+		ch <- result{line, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-ch:
+		return r.line, r.err
+	}
+}
+
+// parseAuthCode extracts the code from the pasted redirect URL, its state must be the one sent
+// with the authorization URL. The input is taken as the code itself only when the site shows the code,
+// as no state comes back then.
+func parseAuthCode(input, state string, bareCode bool) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", errors.New("empty input")
+	}
+
+	if bareCode {
+		return input, nil
+	}
+
+	query := input
+	if i := strings.Index(input, "?"); i >= 0 {
+		query = input[i+1:]
+	}
+	if i := strings.Index(query, "#"); i >= 0 {
+		query = query[:i]
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("error parsing redirect url: %w", err)
+	}
+
+	if e := values.Get("error"); e != "" {
+		if desc := values.Get("error_description"); desc != "" {
+			return "", fmt.Errorf("authorization failed: %s: %s", e, desc)
+		}
+		return "", fmt.Errorf("authorization failed: %s", e)
+	}
+
+	code := values.Get("code")
+	if code == "" {
+		return "", errors.New("no code in redirect url, paste the whole URL you were redirected to")
+	}
+
+	switch s := values.Get("state"); {
+	case s == "":
+		return "", errors.New("no state in redirect url, paste the whole URL you were redirected to")
+	case s != state:
+		return "", errors.New("state mismatch, use the URL printed by this run")
+	}
+
+	return code, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestParseAuthCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		bareCode bool
+		want     string
+		wantErr  bool
+	}{
+		{name: "redirect url", input: "http://localhost:18080/callback?code=abc&state=s1\n", want: "abc"},
+		{name: "query only", input: "code=abc&state=s1", want: "abc"},
+		{name: "state mismatch", input: "http://localhost:18080/callback?code=abc&state=s2", wantErr: true},
+		{name: "state missing", input: "http://localhost:18080/callback?code=abc", wantErr: true},
+		{name: "bare code", input: "abc", wantErr: true},
+		{name: "authorization error", input: "http://localhost:18080/callback?error=access_denied&state=s1", wantErr: true},
+		{name: "bare code shown by the site", input: " abc\n", bareCode: true, want: "abc"},
+		{name: "empty", input: "\n", bareCode: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAuthCode(tt.input, "s1", tt.bareCode)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q, error %t", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const defaultRequestTimeout = 10 * time.Minute
+
+// HTTPConfig configures the HTTP clients used for the APIs and the token requests.
+type HTTPConfig struct {
+	Timeout   string `yaml:"timeout"`    // request timeout, e.g. 30s, 10m by default
+	Proxy     string `yaml:"proxy"`      // proxy URL, HTTP_PROXY and HTTPS_PROXY are used when empty
+	CAFile    string `yaml:"ca_file"`    // PEM bundle trusted in addition to the system roots
+	UserAgent string `yaml:"user_agent"` // User-Agent header, Go's default when empty
+
+	Verbose bool `yaml:"-"` // logs every rate limit wait, set by the -verbose flag
+}
+
+func (c HTTPConfig) timeout() (time.Duration, error) {
+	if c.Timeout == "" {
+		return defaultRequestTimeout, nil
+	}
+
+	d, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid http timeout: %w", err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("http timeout must be positive: %s", d)
+	}
+	return d, nil
+}
+
+// transport returns the base transport with the proxy, CA bundle and User-Agent applied.
+func (c HTTPConfig) transport() (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http proxy: %w", err)
+		}
+		t.Proxy = http.ProxyURL(proxy)
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading ca file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca file: %s", c.CAFile)
+		}
+
+		t.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	if c.UserAgent == "" {
+		return t, nil
+	}
+
+	return &userAgentTransport{base: t, userAgent: c.UserAgent}, nil
+}
+
+// withHTTPClient makes oauth2 use the configured transport for the token requests
+// and as the base of the clients created from the context.
+func withHTTPClient(ctx context.Context, c HTTPConfig) (context.Context, error) {
+	t, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := c.timeout()
+	if err != nil {
+		return nil, err
+	}
+
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: t, Timeout: timeout}), nil
+}
+
+// newOAuthHTTPClient creates the client authorized by the token, rate limited to requestsPerMinute.
+func newOAuthHTTPClient(ctx context.Context, oauth *OAuth, c HTTPConfig, name string, requestsPerMinute int) (*http.Client, error) {
+	ctx, err := withHTTPClient(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := c.timeout()
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := oauth2.NewClient(ctx, oauth.TokenSource())
+	httpClient.Timeout = timeout
+	transport := NewRateLimitTransport(name, httpClient.Transport, requestsPerMinute)
+	transport.Verbose = c.Verbose
+	httpClient.Transport = transport
+
+	return httpClient, nil
+}
+
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IgnoreConfig lists entries that are never synced.
+type IgnoreConfig struct {
+	AnilistIDs []int    `yaml:"anilist_ids"`
+	MalIDs     []int    `yaml:"mal_ids"`
+	Titles     []string `yaml:"titles"`   // exact match with any of English, Japanese or Romaji titles, case insensitive
+	Patterns   []string `yaml:"patterns"` // regular expressions matched against the same titles
+}
+
+type IgnoresConfig struct {
+	Anime IgnoreConfig `yaml:"anime"`
+	Manga IgnoreConfig `yaml:"manga"`
+}
+
+type IgnoreRules struct {
+	anilistIDs map[int]struct{}
+	malIDs     map[TargetID]struct{}
+	titles     map[string]struct{}
+	patterns   []*regexp.Regexp
+}
+
+// NewIgnoreRules merges all given configs into one set of rules.
+func NewIgnoreRules(configs ...IgnoreConfig) (*IgnoreRules, error) {
+	r := &IgnoreRules{
+		anilistIDs: make(map[int]struct{}),
+		malIDs:     make(map[TargetID]struct{}),
+		titles:     make(map[string]struct{}),
+	}
+
+	for _, cfg := range configs {
+		for _, id := range cfg.AnilistIDs {
+			r.anilistIDs[id] = struct{}{}
+		}
+		for _, id := range cfg.MalIDs {
+			r.malIDs[TargetID(id)] = struct{}{}
+		}
+		for _, title := range cfg.Titles {
+			r.titles[strings.ToLower(title)] = struct{}{}
+		}
+		for _, pattern := range cfg.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ignore pattern %q: %w", pattern, err)
+			}
+			r.patterns = append(r.patterns, re)
+		}
+	}
+
+	return r, nil
+}
+
+// Match returns the rule that matches the source.
+func (r *IgnoreRules) Match(src Source) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+
+	if _, ok := r.anilistIDs[src.GetAnilistID()]; ok {
+		return fmt.Sprintf("anilist id %d", src.GetAnilistID()), true
+	}
+
+	if _, ok := r.malIDs[src.GetTargetID()]; ok {
+		return fmt.Sprintf("mal id %d", src.GetTargetID()), true
+	}
+
+	for _, title := range src.GetTitles() {
+		if _, ok := r.titles[strings.ToLower(title)]; ok {
+			return fmt.Sprintf("title %q", title), true
+		}
+	}
+
+	for _, re := range r.patterns {
+		for _, title := range src.GetTitles() {
+			if re.MatchString(title) {
+				return fmt.Sprintf("pattern %q", re.String()), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// ignoreFlag collects one-off ignore rules from the command line,
+// e.g. -ignore=anilist:123 -ignore=mal:456 -ignore="title:Some Title" -ignore="regex:(?i)recap".
+type ignoreFlag struct {
+	IgnoreConfig
+}
+
+func (f *ignoreFlag) String() string {
+	return ""
+}
+
+func (f *ignoreFlag) Set(value string) error {
+	kind, v, ok := strings.Cut(value, ":")
+	if !ok || v == "" {
+		return fmt.Errorf("expected kind:value, got %q", value)
+	}
+
+	switch kind {
+	case "anilist":
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid anilist id: %w", err)
+		}
+		f.AnilistIDs = append(f.AnilistIDs, id)
+	case "mal":
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid mal id: %w", err)
+		}
+		f.MalIDs = append(f.MalIDs, id)
+	case "title":
+		f.Titles = append(f.Titles, v)
+	case "regex":
+		f.Patterns = append(f.Patterns, v)
+	default:
+		return fmt.Errorf("unknown ignore kind: %s", kind)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var errSkippedByUser = errors.New("skipped by user")
+
+// stdin is the only reader of the standard input, the authorization codes and the answers to the prompts
+// are read through it so no reader buffers the input of another one.
+var stdin = bufio.NewReader(os.Stdin)
+
+type describer interface {
+	Describe() string
+}
+
+// Prompter asks the user to choose the target for sources matched by name
+// and saves the choices to the mappings file, so they are never asked again.
+type Prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	mappingsFilePath string
+	mappings         *MappingsConfig
+}
+
+func NewPrompter(in io.Reader, out io.Writer, mappingsFilePath string) (*Prompter, error) {
+	mappings, err := readMappingsFile(mappingsFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Prompter{
+		in:               bufio.NewReader(in),
+		out:              out,
+		mappingsFilePath: mappingsFilePath,
+		mappings:         mappings,
+	}, nil
+}
+
+// chooseTarget prints the candidates found by name and lets the user pick one,
+// skip the source or enter the target ID.
+func (u *Updater) chooseTarget(ctx context.Context, src Source, tgts []Target) (Target, error) {
+	p := u.Prompter
+
+	fmt.Fprintf(p.out, "\n[%s] Choose a match for: %s\n", u.Prefix, describe(src))
+
+	suggested := 0
+	for i, tgt := range tgts {
+		mark := " "
+		if suggested == 0 && src.SameTypeWithTarget(tgt) {
+			suggested = i + 1
+			mark = "*"
+		}
+		fmt.Fprintf(p.out, "%s %d) %s\n", mark, i+1, describe(tgt))
+	}
+
+	for {
+		fmt.Fprintf(p.out, "Number, s = skip, id <ID> = enter ID")
+		if suggested > 0 {
+			fmt.Fprintf(p.out, ", empty = %d", suggested)
+		}
+		fmt.Fprint(p.out, ": ")
+
+		line, err := p.in.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("error reading choice: %w", err)
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "" && suggested > 0:
+			return u.saveChoice(src, tgts[suggested-1])
+		case line == "s":
+			if err := p.save(u.Prefix, Mapping{AnilistID: src.GetAnilistID(), Skip: true}); err != nil {
+				return nil, err
+			}
+			u.Mappings.Add(Mapping{AnilistID: src.GetAnilistID(), Skip: true})
+			return nil, errSkippedByUser
+		case strings.HasPrefix(line, "id "):
+			id, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "id ")))
+			if err != nil || id <= 0 {
+				fmt.Fprintln(p.out, "Invalid ID")
+				continue
+			}
+			tgt, err := u.GetTargetByIDFunc(ctx, TargetID(id))
+			if err != nil {
+				fmt.Fprintf(p.out, "Error getting target by id: %v\n", err)
+				continue
+			}
+			return u.saveChoice(src, tgt)
+		default:
+			n, err := strconv.Atoi(line)
+			if err != nil || n < 1 || n > len(tgts) {
+				fmt.Fprintln(p.out, "Invalid choice")
+				continue
+			}
+			return u.saveChoice(src, tgts[n-1])
+		}
+	}
+}
+
+func (u *Updater) saveChoice(src Source, tgt Target) (Target, error) {
+	m := Mapping{AnilistID: src.GetAnilistID(), MalID: int(tgt.GetTargetID())}
+	if err := u.Prompter.save(u.Prefix, m); err != nil {
+		return nil, err
+	}
+	u.Mappings.Add(m)
+	return tgt, nil
+}
+
+func (p *Prompter) save(prefix string, m Mapping) error {
+	if m.AnilistID <= 0 { // nothing to key the choice by
+		return nil
+	}
+
+	p.mappings.Add(prefix, m)
+
+	if err := writeMappingsFile(p.mappingsFilePath, p.mappings); err != nil {
+		return fmt.Errorf("error saving mapping: %w", err)
+	}
+
+	return nil
+}
+
+func describe(v any) string {
+	if d, ok := v.(describer); ok {
+		return d.Describe()
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,870 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kitsuDefaultAPIURL   = "https://kitsu.app/api/edge"
+	kitsuDefaultTokenURL = "https://kitsu.app/api/oauth/token"
+	kitsuContentType     = "application/vnd.api+json"
+
+	kitsuRequestsPerMinute = 60
+	kitsuPageLimit         = 100
+	kitsuSearchLimit       = 5
+)
+
+// KitsuClient talks to the Kitsu JSON:API, library entries are matched with MAL IDs through the Kitsu mappings.
+type KitsuClient struct {
+	http    *http.Client
+	baseURL string
+
+	mu     sync.Mutex
+	userID string
+}
+
+func NewKitsuClient(ctx context.Context, oauth *OAuth, config SiteConfig, httpConfig HTTPConfig) (*KitsuClient, error) {
+	httpClient, err := newOAuthHTTPClient(ctx, oauth, httpConfig, "Kitsu", kitsuRequestsPerMinute)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := kitsuDefaultAPIURL
+	if config.APIURL != "" {
+		baseURL = strings.TrimSuffix(config.APIURL, "/")
+	}
+
+	return &KitsuClient{http: httpClient, baseURL: baseURL}, nil
+}
+
+// NewKitsuOAuth loads the Kitsu token or requests a new one with the password grant.
+func NewKitsuOAuth(ctx context.Context, config Config) (*OAuth, error) {
+	oauthKitsu, err := newKitsuOAuth(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if oauthKitsu.NeedInit() {
+		if err := oauthKitsu.PasswordToken(ctx, config.Kitsu.Username, config.Kitsu.Password); err != nil {
+			return nil, fmt.Errorf("error getting kitsu token: %w", err)
+		}
+	} else {
+		log.Println("Token already set, no need to log in")
+	}
+
+	return oauthKitsu, nil
+}
+
+// newKitsuOAuth loads the Kitsu token without logging in.
+func newKitsuOAuth(ctx context.Context, config Config) (*OAuth, error) {
+	ctx, err := withHTTPClient(ctx, config.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	site := config.Kitsu
+	if site.TokenURL == "" {
+		site.TokenURL = kitsuDefaultTokenURL
+	}
+
+	return NewOAuth(ctx, site, "", "kitsu", nil, config.TokenFilePath)
+}
+
+type kitsuDocument struct {
+	Data     json.RawMessage `json:"data"`
+	Included []kitsuResource `json:"included"`
+	Links    struct {
+		Next string `json:"next"`
+	} `json:"links"`
+	Errors []struct {
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	} `json:"errors"`
+}
+
+type kitsuResource struct {
+	ID            string                       `json:"id"`
+	Type          string                       `json:"type"`
+	Attributes    json.RawMessage              `json:"attributes"`
+	Relationships map[string]kitsuRelationship `json:"relationships"`
+}
+
+// kitsuRelationship data is a single resource identifier or a list of them.
+type kitsuRelationship struct {
+	Data json.RawMessage `json:"data"`
+}
+
+type kitsuResourceID struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+func (r kitsuRelationship) ids() []kitsuResourceID {
+	var one kitsuResourceID
+	if err := json.Unmarshal(r.Data, &one); err == nil && one.ID != "" {
+		return []kitsuResourceID{one}
+	}
+	var many []kitsuResourceID
+	_ = json.Unmarshal(r.Data, &many)
+	return many
+}
+
+type kitsuLibraryEntryAttributes struct {
+	Status         string     `json:"status"`
+	Progress       int        `json:"progress"`
+	Reconsuming    bool       `json:"reconsuming"`
+	ReconsumeCount int        `json:"reconsumeCount"`
+	RatingTwenty   *int       `json:"ratingTwenty"`
+	Notes          *string    `json:"notes"`
+	Private        bool       `json:"private"`
+	StartedAt      *time.Time `json:"startedAt"`
+	FinishedAt     *time.Time `json:"finishedAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+type kitsuMediaAttributes struct {
+	CanonicalTitle string            `json:"canonicalTitle"`
+	Titles         map[string]string `json:"titles"`
+	Subtype        string            `json:"subtype"`
+	StartDate      string            `json:"startDate"`
+	EpisodeCount   *int              `json:"episodeCount"`
+	ChapterCount   *int              `json:"chapterCount"`
+	VolumeCount    *int              `json:"volumeCount"`
+}
+
+type kitsuMappingAttributes struct {
+	ExternalSite string `json:"externalSite"`
+	ExternalID   string `json:"externalId"`
+}
+
+// kitsuItem is a media with the user's library entry, the entry is nil when the media is not in the library.
+type kitsuItem struct {
+	EntryID string
+	Entry   *kitsuLibraryEntryAttributes
+	MediaID string
+	Media   kitsuMediaAttributes
+	MalID   int
+}
+
+// GetLibrary returns the user's library entries of the kind, anime or manga.
+func (c *KitsuClient) GetLibrary(ctx context.Context, kind string) ([]kitsuItem, error) {
+	userID, err := c.getUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	next := "/library-entries?" + url.Values{
+		"filter[userId]": {userID},
+		"filter[kind]":   {kind},
+		"include":        {kind + "," + kind + ".mappings"},
+		"page[limit]":    {strconv.Itoa(kitsuPageLimit)},
+	}.Encode()
+
+	var items []kitsuItem
+	for next != "" {
+		var doc kitsuDocument
+		if err := c.do(ctx, http.MethodGet, next, nil, &doc); err != nil {
+			return nil, err
+		}
+
+		page, err := newKitsuItemsFromEntries(doc, kind)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+
+		next = doc.Links.Next
+	}
+
+	return items, nil
+}
+
+// GetByMalID returns the media mapped to the MAL ID with the user's library entry.
+func (c *KitsuClient) GetByMalID(ctx context.Context, kind string, malID int) (*kitsuItem, error) {
+	path := "/mappings?" + url.Values{
+		"filter[externalSite]": {"myanimelist/" + kind},
+		"filter[externalId]":   {strconv.Itoa(malID)},
+		"include":              {"item"},
+	}.Encode()
+
+	var doc kitsuDocument
+	if err := c.do(ctx, http.MethodGet, path, nil, &doc); err != nil {
+		return nil, err
+	}
+
+	var mappings []kitsuResource
+	if err := json.Unmarshal(doc.Data, &mappings); err != nil {
+		return nil, fmt.Errorf("error decoding kitsu mappings: %w", err)
+	}
+
+	for _, m := range mappings {
+		for _, id := range m.Relationships["item"].ids() {
+			media, ok := findKitsuResource(doc.Included, id)
+			if !ok {
+				continue
+			}
+
+			item := kitsuItem{MediaID: media.ID, MalID: malID}
+			if err := json.Unmarshal(media.Attributes, &item.Media); err != nil {
+				return nil, fmt.Errorf("error decoding kitsu %s: %w", kind, err)
+			}
+
+			entryID, entry, err := c.getEntry(ctx, kind, media.ID)
+			if err != nil {
+				return nil, err
+			}
+			item.EntryID, item.Entry = entryID, entry
+
+			return &item, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no kitsu %s for mal id %d", kind, malID)
+}
+
+// Search returns the media found by the text, without library entries.
+func (c *KitsuClient) Search(ctx context.Context, kind, text string) ([]kitsuItem, error) {
+	path := "/" + kind + "?" + url.Values{
+		"filter[text]": {text},
+		"include":      {"mappings"},
+		"page[limit]":  {strconv.Itoa(kitsuSearchLimit)},
+	}.Encode()
+
+	var doc kitsuDocument
+	if err := c.do(ctx, http.MethodGet, path, nil, &doc); err != nil {
+		return nil, err
+	}
+
+	var medias []kitsuResource
+	if err := json.Unmarshal(doc.Data, &medias); err != nil {
+		return nil, fmt.Errorf("error decoding kitsu %s: %w", kind, err)
+	}
+
+	items := make([]kitsuItem, 0, len(medias))
+	for _, media := range medias {
+		item := kitsuItem{MediaID: media.ID, MalID: kitsuMalID(media, doc.Included, kind)}
+		if err := json.Unmarshal(media.Attributes, &item.Media); err != nil {
+			return nil, fmt.Errorf("error decoding kitsu %s: %w", kind, err)
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// SaveEntry updates the user's library entry of the item or creates it.
+func (c *KitsuClient) SaveEntry(ctx context.Context, kind string, item kitsuItem, attributes map[string]any) error {
+	if item.EntryID != "" {
+		body := map[string]any{"data": map[string]any{
+			"id":         item.EntryID,
+			"type":       "libraryEntries",
+			"attributes": attributes,
+		}}
+		return c.do(ctx, http.MethodPatch, "/library-entries/"+item.EntryID, body, nil)
+	}
+
+	userID, err := c.getUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]any{"data": map[string]any{
+		"type":       "libraryEntries",
+		"attributes": attributes,
+		"relationships": map[string]any{
+			"user": map[string]any{"data": kitsuResourceID{ID: userID, Type: "users"}},
+			kind:   map[string]any{"data": kitsuResourceID{ID: item.MediaID, Type: kind}},
+		},
+	}}
+	return c.do(ctx, http.MethodPost, "/library-entries", body, nil)
+}
+
+// GetUserName returns the name of the authorized user.
+func (c *KitsuClient) GetUserName(ctx context.Context) (string, error) {
+	user, err := c.getSelf(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var attrs struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(user.Attributes, &attrs); err != nil {
+		return "", fmt.Errorf("error decoding kitsu user: %w", err)
+	}
+	return attrs.Name, nil
+}
+
+func (c *KitsuClient) getUserID(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.userID != "" {
+		return c.userID, nil
+	}
+
+	user, err := c.getSelf(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	c.userID = user.ID
+	return c.userID, nil
+}
+
+func (c *KitsuClient) getSelf(ctx context.Context) (*kitsuResource, error) {
+	var doc kitsuDocument
+	if err := c.do(ctx, http.MethodGet, "/users?filter[self]=true", nil, &doc); err != nil {
+		return nil, err
+	}
+
+	var users []kitsuResource
+	if err := json.Unmarshal(doc.Data, &users); err != nil {
+		return nil, fmt.Errorf("error decoding kitsu user: %w", err)
+	}
+	if len(users) == 0 {
+		return nil, errors.New("kitsu: no authorized user")
+	}
+	return &users[0], nil
+}
+
+func (c *KitsuClient) getEntry(ctx context.Context, kind, mediaID string) (string, *kitsuLibraryEntryAttributes, error) {
+	userID, err := c.getUserID(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	path := "/library-entries?" + url.Values{
+		"filter[userId]":         {userID},
+		"filter[" + kind + "Id]": {mediaID},
+	}.Encode()
+
+	var doc kitsuDocument
+	if err := c.do(ctx, http.MethodGet, path, nil, &doc); err != nil {
+		return "", nil, err
+	}
+
+	var entries []kitsuResource
+	if err := json.Unmarshal(doc.Data, &entries); err != nil {
+		return "", nil, fmt.Errorf("error decoding kitsu library entries: %w", err)
+	}
+	if len(entries) == 0 {
+		return "", nil, nil
+	}
+
+	var attrs kitsuLibraryEntryAttributes
+	if err := json.Unmarshal(entries[0].Attributes, &attrs); err != nil {
+		return "", nil, fmt.Errorf("error decoding kitsu library entry: %w", err)
+	}
+	return entries[0].ID, &attrs, nil
+}
+
+// do sends the request to the path, relative to the API URL or absolute as in pagination links.
+func (c *KitsuClient) do(ctx context.Context, method, path string, body, v any) error {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		path = c.baseURL + path
+	}
+
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", kitsuContentType)
+	if body != nil {
+		req.Header.Set("Content-Type", kitsuContentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var doc kitsuDocument
+		if err := json.Unmarshal(data, &doc); err == nil && len(doc.Errors) > 0 {
+			return fmt.Errorf("kitsu: %d: %s %s", resp.StatusCode, doc.Errors[0].Title, doc.Errors[0].Detail)
+		}
+		return fmt.Errorf("kitsu: unexpected status code: %d", resp.StatusCode)
+	}
+
+	if v == nil || len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func newKitsuItemsFromEntries(doc kitsuDocument, kind string) ([]kitsuItem, error) {
+	var entries []kitsuResource
+	if err := json.Unmarshal(doc.Data, &entries); err != nil {
+		return nil, fmt.Errorf("error decoding kitsu library entries: %w", err)
+	}
+
+	items := make([]kitsuItem, 0, len(entries))
+	for _, entry := range entries {
+		item := kitsuItem{EntryID: entry.ID, Entry: new(kitsuLibraryEntryAttributes)}
+		if err := json.Unmarshal(entry.Attributes, item.Entry); err != nil {
+			return nil, fmt.Errorf("error decoding kitsu library entry: %w", err)
+		}
+
+		for _, id := range entry.Relationships[kind].ids() {
+			media, ok := findKitsuResource(doc.Included, id)
+			if !ok {
+				continue
+			}
+			item.MediaID = media.ID
+			item.MalID = kitsuMalID(media, doc.Included, kind)
+			if err := json.Unmarshal(media.Attributes, &item.Media); err != nil {
+				return nil, fmt.Errorf("error decoding kitsu %s: %w", kind, err)
+			}
+		}
+
+		if item.MediaID == "" {
+			log.Printf("Kitsu library entry %s has no %s", entry.ID, kind)
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// kitsuMalID returns the MAL ID from the included mappings of the media, 0 if not mapped.
+func kitsuMalID(media kitsuResource, included []kitsuResource, kind string) int {
+	for _, id := range media.Relationships["mappings"].ids() {
+		m, ok := findKitsuResource(included, id)
+		if !ok {
+			continue
+		}
+
+		var attrs kitsuMappingAttributes
+		if err := json.Unmarshal(m.Attributes, &attrs); err != nil {
+			continue
+		}
+		if attrs.ExternalSite != "myanimelist/"+kind {
+			continue
+		}
+
+		malID, err := strconv.Atoi(attrs.ExternalID)
+		if err == nil {
+			return malID
+		}
+	}
+	return 0
+}
+
+func findKitsuResource(resources []kitsuResource, id kitsuResourceID) (kitsuResource, bool) {
+	for _, r := range resources {
+		if r.ID == id.ID && r.Type == id.Type {
+			return r, true
+		}
+	}
+	return kitsuResource{}, false
+}
+
+// kitsuScore converts the 2-20 rating to MAL's 0-10 scale.
+func kitsuScore(ratingTwenty *int) float64 {
+	if ratingTwenty == nil {
+		return 0
+	}
+	return math.Round(float64(*ratingTwenty) / 2)
+}
+
+// kitsuRating converts MAL's 0-10 score to the 2-20 rating, nil removes the rating.
+func kitsuRating(score float64) *int {
+	if score <= 0 {
+		return nil
+	}
+	r := int(math.Round(score * 2))
+	return &r
+}
+
+func (m kitsuMediaAttributes) titles() (en, jp, romaji string) {
+	en, jp, romaji = m.Titles["en"], m.Titles["ja_jp"], m.Titles["en_jp"]
+	if en == "" {
+		en = m.CanonicalTitle
+	}
+	return en, jp, romaji
+}
+
+func (m kitsuMediaAttributes) year() int {
+	return parseYear(m.StartDate)
+}
+
+func (e *kitsuLibraryEntryAttributes) notes() string {
+	if e.Notes == nil {
+		return ""
+	}
+	return *e.Notes
+}
+
+// kitsuEntryAttributes returns the library entry attributes shared by anime and manga.
+func kitsuEntryAttributes(status string, progress int, score float64, reconsuming bool, reconsumeCount int,
+	notes string, private bool, startedAt, finishedAt *time.Time,
+) map[string]any {
+	attrs := map[string]any{
+		"status":         status,
+		"progress":       progress,
+		"ratingTwenty":   kitsuRating(score),
+		"reconsuming":    reconsuming,
+		"reconsumeCount": reconsumeCount,
+		"notes":          notes,
+		"private":        private,
+	}
+	if startedAt != nil {
+		attrs["startedAt"] = startedAt.UTC().Format(time.RFC3339)
+	}
+	if finishedAt != nil {
+		attrs["finishedAt"] = finishedAt.UTC().Format(time.RFC3339)
+	}
+	return attrs
+}
+
+func newAnimeFromKitsuItem(item kitsuItem) Anime {
+	en, jp, romaji := item.Media.titles()
+
+	a := Anime{
+		IDAnilist:   -1,
+		IDMal:       item.MalID,
+		SeasonYear:  item.Media.year(),
+		MediaType:   item.Media.Subtype,
+		Status:      StatusUnknown,
+		TitleEN:     en,
+		TitleJP:     jp,
+		TitleRomaji: romaji,
+	}
+	if item.Media.EpisodeCount != nil {
+		a.NumEpisodes = *item.Media.EpisodeCount
+	}
+
+	if e := item.Entry; e != nil {
+		a.Status = mapKitsuStatusToStatus(e.Status)
+		a.Progress = e.Progress
+		a.Score = kitsuScore(e.RatingTwenty)
+		a.StartedAt = e.StartedAt
+		a.FinishedAt = e.FinishedAt
+		a.UpdatedAt = e.UpdatedAt
+		a.IsRewatching = e.Reconsuming
+		a.NumTimesRewatched = e.ReconsumeCount
+		a.Notes = e.notes()
+		a.Private = e.Private
+	}
+
+	return a
+}
+
+func newMangaFromKitsuItem(item kitsuItem) Manga {
+	en, jp, romaji := item.Media.titles()
+
+	m := Manga{
+		IDAnilist:   -1,
+		IDMal:       item.MalID,
+		Year:        item.Media.year(),
+		MediaType:   item.Media.Subtype,
+		Status:      MangaStatusUnknown,
+		TitleEN:     en,
+		TitleJP:     jp,
+		TitleRomaji: romaji,
+	}
+	if item.Media.ChapterCount != nil {
+		m.Chapters = *item.Media.ChapterCount
+	}
+	if item.Media.VolumeCount != nil {
+		m.Volumes = *item.Media.VolumeCount
+	}
+
+	if e := item.Entry; e != nil {
+		m.Status = mapKitsuStatusToMangaStatus(e.Status)
+		m.Progress = e.Progress
+		m.Score = kitsuScore(e.RatingTwenty)
+		m.StartedAt = e.StartedAt
+		m.FinishedAt = e.FinishedAt
+		m.UpdatedAt = e.UpdatedAt
+		m.IsRereading = e.Reconsuming
+		m.NumTimesReread = e.ReconsumeCount
+		m.Notes = e.notes()
+		m.Private = e.Private
+	}
+
+	return m
+}
+
+func mapKitsuStatusToStatus(s string) Status {
+	switch s {
+	case "current":
+		return StatusWatching
+	case "completed":
+		return StatusCompleted
+	case "on_hold":
+		return StatusOnHold
+	case "dropped":
+		return StatusDropped
+	case "planned":
+		return StatusPlanToWatch
+	default:
+		return StatusUnknown
+	}
+}
+
+func mapKitsuStatusToMangaStatus(s string) MangaStatus {
+	switch s {
+	case "current":
+		return MangaStatusReading
+	case "completed":
+		return MangaStatusCompleted
+	case "on_hold":
+		return MangaStatusOnHold
+	case "dropped":
+		return MangaStatusDropped
+	case "planned":
+		return MangaStatusPlanToRead
+	default:
+		return MangaStatusUnknown
+	}
+}
+
+func (s Status) GetKitsuStatus() (string, error) {
+	switch s {
+	case StatusWatching:
+		return "current", nil
+	case StatusCompleted:
+		return "completed", nil
+	case StatusOnHold:
+		return "on_hold", nil
+	case StatusDropped:
+		return "dropped", nil
+	case StatusPlanToWatch:
+		return "planned", nil
+	default:
+		return "", errStatusUnknown
+	}
+}
+
+func (s MangaStatus) GetKitsuStatus() (string, error) {
+	switch s {
+	case MangaStatusReading:
+		return "current", nil
+	case MangaStatusCompleted:
+		return "completed", nil
+	case MangaStatusOnHold:
+		return "on_hold", nil
+	case MangaStatusDropped:
+		return "dropped", nil
+	case MangaStatusPlanToRead:
+		return "planned", nil
+	default:
+		return "", errStatusUnknown
+	}
+}
+
+func (a Anime) GetKitsuAttributes() (map[string]any, error) {
+	st, err := a.Status.GetKitsuStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var finishedAt *time.Time
+	if a.Status == StatusCompleted {
+		finishedAt = a.FinishedAt
+	}
+
+	attrs := kitsuEntryAttributes(st, a.Progress, a.Score, a.IsRewatching, a.NumTimesRewatched,
+		a.Notes, a.Private, a.StartedAt, finishedAt)
+	if !a.writesNotes() {
+		delete(attrs, "notes")
+	}
+	return attrs, nil
+}
+
+func (m Manga) GetKitsuAttributes() (map[string]any, error) {
+	st, err := m.Status.GetKitsuStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var finishedAt *time.Time
+	if m.Status == MangaStatusCompleted {
+		finishedAt = m.FinishedAt
+	}
+
+	attrs := kitsuEntryAttributes(st, m.Progress, m.Score, m.IsRereading, m.NumTimesReread,
+		m.Notes, m.Private, m.StartedAt, finishedAt)
+	if !m.writesNotes() {
+		delete(attrs, "notes")
+	}
+	return attrs, nil
+}
+
+type kitsuAnimeTracker struct {
+	c *KitsuClient
+}
+
+func (t *kitsuAnimeTracker) Name() string { return "Kitsu" }
+
+func (t *kitsuAnimeTracker) GetList(ctx context.Context) ([]Entry, error) {
+	items, err := t.c.GetLibrary(ctx, "anime")
+	if err != nil {
+		return nil, fmt.Errorf("error getting user anime list from kitsu: %w", err)
+	}
+
+	res := make([]Entry, 0, len(items))
+	for _, item := range items {
+		res = append(res, newAnimeFromKitsuItem(item))
+	}
+	return res, nil
+}
+
+func (t *kitsuAnimeTracker) GetByID(ctx context.Context, id TargetID) (Target, error) {
+	item, err := t.c.GetByMalID(ctx, "anime", int(id))
+	if err != nil {
+		return nil, fmt.Errorf("error getting anime by mal id: %w", err)
+	}
+	return newAnimeFromKitsuItem(*item), nil
+}
+
+func (t *kitsuAnimeTracker) Search(ctx context.Context, name string) ([]Target, error) {
+	items, err := t.c.Search(ctx, "anime", name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting anime by name: %w", err)
+	}
+
+	res := make([]Target, 0, len(items))
+	for _, item := range items {
+		res = append(res, newAnimeFromKitsuItem(item))
+	}
+	return res, nil
+}
+
+func (t *kitsuAnimeTracker) Update(ctx context.Context, id TargetID, src Source) error {
+	a, ok := src.(Anime)
+	if !ok {
+		return fmt.Errorf("source is not an anime")
+	}
+
+	attrs, err := a.GetKitsuAttributes()
+	if err != nil {
+		return fmt.Errorf("error getting kitsu attributes: %w", err)
+	}
+
+	item, err := t.c.GetByMalID(ctx, "anime", int(id))
+	if err != nil {
+		return fmt.Errorf("error getting anime by mal id: %w", err)
+	}
+
+	if err := t.c.SaveEntry(ctx, "anime", *item, attrs); err != nil {
+		return fmt.Errorf("error saving anime library entry: %w", err)
+	}
+	return nil
+}
+
+// AdaptSource drops the MAL only fields, Kitsu has no tags, priority and rewatch value.
+func (t *kitsuAnimeTracker) AdaptSource(src Source) Source {
+	a, ok := src.(Anime)
+	if !ok {
+		return src
+	}
+	a.Tags, a.ManagedTags, a.Priority, a.RewatchValue = nil, nil, nil, nil
+	return a
+}
+
+type kitsuMangaTracker struct {
+	c *KitsuClient
+}
+
+func (t *kitsuMangaTracker) Name() string { return "Kitsu" }
+
+func (t *kitsuMangaTracker) GetList(ctx context.Context) ([]Entry, error) {
+	items, err := t.c.GetLibrary(ctx, "manga")
+	if err != nil {
+		return nil, fmt.Errorf("error getting user manga list from kitsu: %w", err)
+	}
+
+	res := make([]Entry, 0, len(items))
+	for _, item := range items {
+		res = append(res, newMangaFromKitsuItem(item))
+	}
+	return res, nil
+}
+
+func (t *kitsuMangaTracker) GetByID(ctx context.Context, id TargetID) (Target, error) {
+	item, err := t.c.GetByMalID(ctx, "manga", int(id))
+	if err != nil {
+		return nil, fmt.Errorf("error getting manga by mal id: %w", err)
+	}
+	return newMangaFromKitsuItem(*item), nil
+}
+
+func (t *kitsuMangaTracker) Search(ctx context.Context, name string) ([]Target, error) {
+	items, err := t.c.Search(ctx, "manga", name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting manga by name: %w", err)
+	}
+
+	res := make([]Target, 0, len(items))
+	for _, item := range items {
+		res = append(res, newMangaFromKitsuItem(item))
+	}
+	return res, nil
+}
+
+func (t *kitsuMangaTracker) Update(ctx context.Context, id TargetID, src Source) error {
+	m, ok := src.(Manga)
+	if !ok {
+		return fmt.Errorf("source is not a manga")
+	}
+
+	attrs, err := m.GetKitsuAttributes()
+	if err != nil {
+		return fmt.Errorf("error getting kitsu attributes: %w", err)
+	}
+
+	item, err := t.c.GetByMalID(ctx, "manga", int(id))
+	if err != nil {
+		return fmt.Errorf("error getting manga by mal id: %w", err)
+	}
+
+	if err := t.c.SaveEntry(ctx, "manga", *item, attrs); err != nil {
+		return fmt.Errorf("error saving manga library entry: %w", err)
+	}
+	return nil
+}
+
+// AdaptSource drops the fields Kitsu doesn't keep: read volumes and the MAL only fields.
+func (t *kitsuMangaTracker) AdaptSource(src Source) Source {
+	m, ok := src.(Manga)
+	if !ok {
+		return src
+	}
+	m.ProgressVolumes = 0
+	m.Tags, m.ManagedTags, m.Priority, m.RereadValue = nil, nil, nil, nil
+	return m
+}
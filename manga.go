@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -39,6 +42,23 @@ func (s MangaStatus) GetMalStatus() (mal.MangaStatus, error) {
 	}
 }
 
+func (s MangaStatus) GetAnilistStatus() (verniy.MediaListStatus, error) {
+	switch s {
+	case MangaStatusReading:
+		return verniy.MediaListStatusCurrent, nil
+	case MangaStatusCompleted:
+		return verniy.MediaListStatusCompleted, nil
+	case MangaStatusOnHold:
+		return verniy.MediaListStatusPaused, nil
+	case MangaStatusDropped:
+		return verniy.MediaListStatusDropped, nil
+	case MangaStatusPlanToRead:
+		return verniy.MediaListStatusPlanning, nil
+	default:
+		return "", errors.New("unknown status")
+	}
+}
+
 type Manga struct {
 	IDAnilist       int
 	IDMal           int
@@ -51,14 +71,47 @@ type Manga struct {
 	TitleRomaji     string
 	Chapters        int
 	Volumes         int
+	MediaType       string
+	Year            int
 	StartedAt       *time.Time
 	FinishedAt      *time.Time
+	UpdatedAt       time.Time
+
+	IsRereading    bool
+	NumTimesReread int
+
+	Notes   string
+	Private bool
+	// Tags are the MAL tags, from AniList custom lists when AniList is the source.
+	Tags []string
+	// ManagedTags are the MAL tags the custom lists are exported as, nil when no custom list is exported.
+	// The other MAL tags are kept.
+	ManagedTags []string
+	// scores is the AniList score format of entries from AniList, the zero value for the others.
+	scores ScoreConverter
+	// tagsMerged is set when Tags include the MAL tags of the target, targetPrivate when it is tagged as private.
+	tagsMerged    bool
+	targetPrivate bool
+	// clearNotes writes empty notes too, clearing the notes of the target.
+	clearNotes bool
+
+	// Priority and RereadValue are MAL only fields, nil when not managed.
+	Priority    *int
+	RereadValue *int
 }
 
 func (m Manga) GetTargetID() TargetID {
 	return TargetID(m.IDMal)
 }
 
+func (m Manga) GetAnilistID() int {
+	return m.IDAnilist
+}
+
+func (m Manga) GetUpdatedAt() time.Time {
+	return m.UpdatedAt
+}
+
 func (m Manga) GetStatusString() string {
 	return string(m.Status)
 }
@@ -74,7 +127,7 @@ func (m Manga) GetStringDiffWithTarget(t Target) string {
 	if m.Status != b.Status {
 		sb.WriteString(fmt.Sprintf("Status: %s -> %s, ", m.Status, b.Status))
 	}
-	if m.Score != b.Score {
+	if !m.scoreConverter(b).SameScore(m.Score, b.Score) {
 		sb.WriteString(fmt.Sprintf("Score: %f -> %f, ", m.Score, b.Score))
 	}
 	if m.Progress != b.Progress {
@@ -83,30 +136,81 @@ func (m Manga) GetStringDiffWithTarget(t Target) string {
 	if m.ProgressVolumes != b.ProgressVolumes {
 		sb.WriteString(fmt.Sprintf("ProgressVolumes: %d -> %d, ", m.ProgressVolumes, b.ProgressVolumes))
 	}
+	if m.IsRereading != b.IsRereading {
+		sb.WriteString(fmt.Sprintf("IsRereading: %t -> %t, ", m.IsRereading, b.IsRereading))
+	}
+	if m.NumTimesReread != b.NumTimesReread {
+		sb.WriteString(fmt.Sprintf("NumTimesReread: %d -> %d, ", m.NumTimesReread, b.NumTimesReread))
+	}
+	if m.writesNotes() && m.Notes != b.Notes {
+		sb.WriteString(fmt.Sprintf("Notes: %q -> %q, ", m.Notes, b.Notes))
+	}
+	if m.Private != b.Private {
+		sb.WriteString(fmt.Sprintf("Private: %t -> %t, ", m.Private, b.Private))
+	}
+	if m.ManagedTags != nil && !slices.Equal(mergeMalTags(b.Tags, m.Tags, m.ManagedTags), b.Tags) {
+		sb.WriteString(fmt.Sprintf("Tags: %v -> %v, ", m.Tags, b.Tags))
+	}
+	if !sameOptionalInt(m.Priority, b.Priority) {
+		sb.WriteString(fmt.Sprintf("Priority: %d -> %d, ", *m.Priority, optionalInt(b.Priority)))
+	}
+	if !sameOptionalInt(m.RereadValue, b.RereadValue) {
+		sb.WriteString(fmt.Sprintf("RereadValue: %d -> %d, ", *m.RereadValue, optionalInt(b.RereadValue)))
+	}
 	sb.WriteString("}")
 	return sb.String()
 }
 
-func (m Manga) SameProgressWithTarget(t Target) bool {
+// SameProgressWithTarget reports whether the target is up to date, debugf explains the difference.
+func (m Manga) SameProgressWithTarget(t Target, debugf func(format string, v ...any)) bool {
 	b, ok := t.(Manga)
 	if !ok {
 		return false
 	}
 
 	if m.Status != b.Status {
-		DPrintf("Status: %s != %s", m.Status, b.Status)
+		debugf("Status: %s != %s", m.Status, b.Status)
 		return false
 	}
-	if m.Score != b.Score {
-		DPrintf("Score: %f != %f", m.Score, b.Score)
+	if !m.scoreConverter(b).SameScore(m.Score, b.Score) {
+		debugf("Score: %f != %f", m.Score, b.Score)
 		return false
 	}
 	if m.Progress != b.Progress {
-		DPrintf("Progress: %d != %d", m.Progress, b.Progress)
+		debugf("Progress: %d != %d", m.Progress, b.Progress)
 		return false
 	}
 	if m.ProgressVolumes != b.ProgressVolumes {
-		DPrintf("ProgressVolumes: %d != %d", m.ProgressVolumes, b.ProgressVolumes)
+		debugf("ProgressVolumes: %d != %d", m.ProgressVolumes, b.ProgressVolumes)
+		return false
+	}
+	if m.IsRereading != b.IsRereading {
+		debugf("IsRereading: %t != %t", m.IsRereading, b.IsRereading)
+		return false
+	}
+	if m.NumTimesReread != b.NumTimesReread {
+		debugf("NumTimesReread: %d != %d", m.NumTimesReread, b.NumTimesReread)
+		return false
+	}
+	if m.writesNotes() && m.Notes != b.Notes {
+		debugf("Notes: %q != %q", m.Notes, b.Notes)
+		return false
+	}
+	if m.Private != b.Private {
+		debugf("Private: %t != %t", m.Private, b.Private)
+		return false
+	}
+	// tags are only pushed to MAL, AniList custom lists are never written
+	if m.ManagedTags != nil && !slices.Equal(mergeMalTags(b.Tags, m.Tags, m.ManagedTags), b.Tags) {
+		debugf("Tags: %v != %v", m.Tags, b.Tags)
+		return false
+	}
+	if !sameOptionalInt(m.Priority, b.Priority) {
+		debugf("Priority: %d != %d", *m.Priority, optionalInt(b.Priority))
+		return false
+	}
+	if !sameOptionalInt(m.RereadValue, b.RereadValue) {
+		debugf("RereadValue: %d != %d", *m.RereadValue, optionalInt(b.RereadValue))
 		return false
 	}
 
@@ -158,6 +262,21 @@ func (m Manga) GetTitle() string {
 	return m.TitleRomaji
 }
 
+func (m Manga) GetTitles() []string {
+	titles := make([]string, 0, 3)
+	for _, title := range []string{m.TitleEN, m.TitleJP, m.TitleRomaji} {
+		if title != "" {
+			titles = append(titles, title)
+		}
+	}
+	return titles
+}
+
+// Describe returns a short human readable description to tell similar entries apart.
+func (m Manga) Describe() string {
+	return fmt.Sprintf("%s (%d, %s, %d ch, %d vol) [id %d]", m.GetTitle(), m.Year, m.MediaType, m.Chapters, m.Volumes, m.IDMal)
+}
+
 func (m Manga) String() string {
 	sb := strings.Builder{}
 	sb.WriteString("Manga{")
@@ -172,7 +291,10 @@ func (m Manga) String() string {
 	sb.WriteString(fmt.Sprintf("Chapters: %d, ", m.Chapters))
 	sb.WriteString(fmt.Sprintf("Volumes: %d, ", m.Volumes))
 	sb.WriteString(fmt.Sprintf("StartedAt: %s, ", m.StartedAt))
-	sb.WriteString(fmt.Sprintf("FinishedAt: %s", m.FinishedAt))
+	sb.WriteString(fmt.Sprintf("FinishedAt: %s, ", m.FinishedAt))
+	sb.WriteString(fmt.Sprintf("UpdatedAt: %s, ", m.UpdatedAt))
+	sb.WriteString(fmt.Sprintf("IsRereading: %t, ", m.IsRereading))
+	sb.WriteString(fmt.Sprintf("NumTimesReread: %d", m.NumTimesReread))
 	sb.WriteString("}")
 	return sb.String()
 }
@@ -189,24 +311,162 @@ func (m Manga) GetUpdateOptions() []mal.UpdateMyMangaListStatusOption {
 		mal.Score(m.Score),
 		mal.NumChaptersRead(m.Progress),
 		mal.NumVolumesRead(m.ProgressVolumes),
+		mal.IsRereading(m.IsRereading),
+		mal.NumTimesReread(m.NumTimesReread),
+	}
+
+	if m.writesNotes() {
+		opts = append(opts, mal.Comments(m.Notes))
+	}
+
+	// tags are written only when they are used and the MAL tags are known, others would clear the MAL tags
+	if m.writesTags() {
+		opts = append(opts, mal.Tags(m.malTags()))
 	}
 
 	if m.StartedAt != nil {
 		opts = append(opts, mal.StartDate(*m.StartedAt))
-	} else {
-		opts = append(opts, mal.StartDate(time.Time{}))
 	}
 
 	if m.Status == MangaStatusCompleted && m.FinishedAt != nil {
 		opts = append(opts, mal.FinishDate(*m.FinishedAt))
+	}
+
+	if m.Priority != nil {
+		opts = append(opts, mal.Priority(*m.Priority))
+	}
+
+	if m.RereadValue != nil {
+		opts = append(opts, mal.RereadValue(*m.RereadValue))
+	}
+
+	return opts
+}
+
+// ApplyPolicy copies the fields the policy doesn't let to write from the target.
+// The status is always written to entries without a status on the target.
+func (m Manga) ApplyPolicy(p SyncPolicy, t Target) Source {
+	b, ok := t.(Manga)
+	if !ok {
+		return m
+	}
+
+	if b.Status != MangaStatusUnknown && b.Status != "" && p.Status.keep(false) {
+		m.Status = b.Status
+	}
+	if p.Score.keep(b.Score == 0) {
+		m.Score = b.Score
+	}
+	if p.Progress.keep(b.Progress == 0) {
+		m.Progress = b.Progress
+	}
+	if p.Volumes.keep(b.ProgressVolumes == 0) {
+		m.ProgressVolumes = b.ProgressVolumes
+	}
+	if p.Dates.keep(b.StartedAt == nil) {
+		m.StartedAt = b.StartedAt
+	}
+	if p.Dates.keep(b.FinishedAt == nil) {
+		m.FinishedAt = b.FinishedAt
+	}
+	if p.Notes.keep(b.Notes == "") {
+		m.Notes = b.Notes
 	} else {
-		opts = append(opts, mal.FinishDate(time.Time{}))
+		m.clearNotes = p.ClearNotes
+	}
+	if p.Rewatch.keep(b.NumTimesReread == 0 && !b.IsRereading) {
+		m.NumTimesReread = b.NumTimesReread
+		m.IsRereading = b.IsRereading
+	}
+
+	return m
+}
+
+// scoreConverter returns the AniList score format of the entry or the other one.
+func (m Manga) scoreConverter(b Manga) ScoreConverter {
+	if m.scores.Format != "" {
+		return m.scores
+	}
+	return b.scores
+}
+
+// MergeTarget adds the MAL tags the custom lists don't manage, the update would remove them otherwise.
+func (m Manga) MergeTarget(t Target) Source {
+	b, ok := t.(Manga)
+	if !ok || m.IDAnilist <= 0 {
+		return m
+	}
+
+	m.Tags = mergeMalTags(b.Tags, m.Tags, m.ManagedTags)
+	m.tagsMerged = true
+	m.targetPrivate = b.Private
+	return m
+}
+
+// NeedsTarget reports whether the entry has tags to write, they need the MAL tags of the target.
+func (m Manga) NeedsTarget() bool {
+	return m.IDAnilist > 0 && (m.ManagedTags != nil || m.Private)
+}
+
+// writesNotes reports whether the update writes the notes, empty notes only when the policy clears them.
+func (m Manga) writesNotes() bool {
+	return m.Notes != "" || m.clearNotes
+}
+
+// writesTags reports whether the update writes the tags: custom lists are exported or the private tag
+// is added or removed, and the MAL tags are merged.
+func (m Manga) writesTags() bool {
+	return m.IDAnilist > 0 && m.tagsMerged && (m.ManagedTags != nil || m.Private || m.targetPrivate)
+}
+
+// malTags returns the MAL tags for the entry, AniList private entries are also tagged as private.
+func (m Manga) malTags() []string {
+	tags := slices.Clone(m.Tags)
+	if m.Private {
+		tags = append(tags, malPrivateTag)
+	}
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+func (m Manga) GetAnilistUpdateOptions() map[string]any {
+	st, err := m.Status.GetAnilistStatus()
+	if err != nil {
+		log.Printf("Error getting AniList status: %v", err)
+		return nil
+	}
+
+	if m.IsRereading {
+		st = verniy.MediaListStatusRepeating
+	}
+
+	opts := map[string]any{
+		"status":          st,
+		"scoreRaw":        int(math.Round(m.Score * 10)),
+		"progress":        m.Progress,
+		"progressVolumes": m.ProgressVolumes,
+		"repeat":          m.NumTimesReread,
+		"private":         m.Private,
+	}
+
+	if m.writesNotes() {
+		opts["notes"] = m.Notes
+	}
+
+	if m.StartedAt != nil {
+		opts["startedAt"] = convertTimeToFuzzyDate(m.StartedAt)
+	}
+
+	if m.Status == MangaStatusCompleted && m.FinishedAt != nil {
+		opts["completedAt"] = convertTimeToFuzzyDate(m.FinishedAt)
 	}
 
 	return opts
 }
 
-func newMangaFromMediaListEntry(mediaList verniy.MediaList) (Manga, error) {
+func newMangaFromMediaListEntry(mediaList verniy.MediaList, scores ScoreConverter) (Manga, error) {
 	if mediaList.Media == nil {
 		return Manga{}, errors.New("media is nil")
 	}
@@ -221,7 +481,7 @@ func newMangaFromMediaListEntry(mediaList verniy.MediaList) (Manga, error) {
 
 	var score float64
 	if mediaList.Score != nil {
-		score = *mediaList.Score
+		score = scores.ToMal(*mediaList.Score)
 	}
 
 	var progress int
@@ -264,6 +524,26 @@ func newMangaFromMediaListEntry(mediaList verniy.MediaList) (Manga, error) {
 		volumes = *mediaList.Media.Volumes
 	}
 
+	var mediaType string
+	if mediaList.Media.Format != nil {
+		mediaType = strings.ToLower(string(*mediaList.Media.Format))
+	}
+
+	var repeat int
+	if mediaList.Repeat != nil {
+		repeat = *mediaList.Repeat
+	}
+
+	var updatedAt time.Time
+	if mediaList.UpdatedAt != nil {
+		updatedAt = time.Unix(int64(*mediaList.UpdatedAt), 0).UTC()
+	}
+
+	var notes string
+	if mediaList.Notes != nil {
+		notes = *mediaList.Notes
+	}
+
 	startedAt := convertFuzzyDateToTimeOrNow(mediaList.StartedAt)
 	finishedAt := convertFuzzyDateToTimeOrNow(mediaList.CompletedAt)
 
@@ -279,11 +559,31 @@ func newMangaFromMediaListEntry(mediaList verniy.MediaList) (Manga, error) {
 		TitleRomaji:     romajiTitle,
 		Chapters:        chapters,
 		Volumes:         volumes,
+		MediaType:       mediaType,
 		StartedAt:       startedAt,
 		FinishedAt:      finishedAt,
+		UpdatedAt:       updatedAt,
+		scores:          scores,
+
+		IsRereading:    *mediaList.Status == verniy.MediaListStatusRepeating,
+		NumTimesReread: repeat,
+
+		Notes:   notes,
+		Private: mediaList.Private != nil && *mediaList.Private,
 	}, nil
 }
 
+// newMangaFromAnilistMedia builds a manga from a media with the viewer's list entry.
+// If the media is not in the list yet, the status is unknown.
+func newMangaFromAnilistMedia(media verniy.Media, scores ScoreConverter) (Manga, error) {
+	entry := verniy.MediaList{Status: new(verniy.MediaListStatus)}
+	if media.MediaListEntry != nil {
+		entry = *media.MediaListEntry
+	}
+	entry.Media = &media
+	return newMangaFromMediaListEntry(entry, scores)
+}
+
 func newMangaFromMalManga(manga mal.Manga) (Manga, error) {
 	if manga.ID == 0 {
 		return Manga{}, errors.New("ID is nil")
@@ -314,11 +614,36 @@ func newMangaFromMalManga(manga mal.Manga) (Manga, error) {
 		TitleRomaji:     "",
 		Chapters:        manga.NumChapters,
 		Volumes:         manga.NumVolumes,
+		MediaType:       manga.MediaType,
+		Year:            parseYear(manga.StartDate),
 		StartedAt:       startedAt,
 		FinishedAt:      finishedAt,
+		UpdatedAt:       manga.MyListStatus.UpdatedAt,
+
+		IsRereading:    manga.MyListStatus.IsRereading,
+		NumTimesReread: manga.MyListStatus.NumTimesReread,
+
+		Notes:   manga.MyListStatus.Comments,
+		Private: hasMalPrivateTag(manga.MyListStatus.Tags),
+		Tags:    malTagsWithoutPrivate(manga.MyListStatus.Tags),
+
+		Priority:    &manga.MyListStatus.Priority,
+		RereadValue: &manga.MyListStatus.RereadValue,
 	}, nil
 }
 
+// parseYear returns the year of a MAL date which can be partial, e.g. "2010" or "2010-05".
+func parseYear(date string) int {
+	if len(date) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(date[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
 func mapMalMangaStatusToStatus(s mal.MangaStatus) MangaStatus {
 	switch s {
 	case mal.MangaStatusReading:
@@ -349,24 +674,46 @@ func mapAnilistMangaStatustToStatus(s verniy.MediaListStatus) MangaStatus {
 	case verniy.MediaListStatusPlanning:
 		return MangaStatusPlanToRead
 	case verniy.MediaListStatusRepeating:
-		return MangaStatusReading // TODO: handle repeating correctly
+		return MangaStatusCompleted // MAL keeps rereading entries completed with the rereading flag
 	default:
 		return MangaStatusUnknown
 	}
 }
 
-func newMangasFromMediaListGroups(groups []verniy.MediaListGroup) []Manga {
-	res := make([]Manga, 0, len(groups))
-	for _, group := range groups {
-		for _, mediaList := range group.Entries {
-			r, err := newMangaFromMediaListEntry(mediaList)
-			if err != nil {
-				log.Printf("Error creating manga from media list entry: %v", err)
-				continue
-			}
+func newMangasFromMediaListGroups(groups []verniy.MediaListGroup, scores ScoreConverter, lists CustomListTags, extra ExtraFields) []Manga {
+	customLists := customListsByMediaID(groups)
+	managed := lists.ManagedTags(groups)
 
-			res = append(res, r)
+	entries := uniqueMediaListEntries(groups)
+
+	res := make([]Manga, 0, len(entries))
+	for _, mediaList := range entries {
+		r, err := newMangaFromMediaListEntry(mediaList, scores)
+		if err != nil {
+			log.Printf("Error creating manga from media list entry: %v", err)
+			continue
 		}
+		names := customLists[r.IDAnilist]
+		r.Tags = lists.Tags(names)
+		r.ManagedTags = managed
+		r.Priority = extra.Priority.Value(names, mediaList.Priority)
+		r.RereadValue = extra.RewatchValue.Value(names, nil)
+
+		res = append(res, r)
+	}
+	return res
+}
+
+func newMangasFromAnilistMedias(medias []verniy.Media, scores ScoreConverter) []Manga {
+	res := make([]Manga, 0, len(medias))
+	for _, media := range medias {
+		r, err := newMangaFromAnilistMedia(media, scores)
+		if err != nil {
+			log.Printf("Error creating manga from anilist media: %v", err)
+			continue
+		}
+
+		res = append(res, r)
 	}
 	return res
 }
@@ -407,8 +754,8 @@ func newTargetsFromMangas(mangas []Manga) []Target {
 	return res
 }
 
-func newSourcesFromMangas(mangas []Manga) []Source {
-	res := make([]Source, 0, len(mangas))
+func newEntriesFromMangas(mangas []Manga) []Entry {
+	res := make([]Entry, 0, len(mangas))
 	for _, manga := range mangas {
 		res = append(res, manga)
 	}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Mapping pins an AniList entry, found by its media ID or title, to a MAL ID or skips it.
+type Mapping struct {
+	AnilistID int    `yaml:"anilist_id" json:"anilist_id,omitempty"`
+	Title     string `yaml:"title" json:"title,omitempty"`
+	MalID     int    `yaml:"mal_id" json:"mal_id,omitempty"`
+	Skip      bool   `yaml:"skip" json:"skip,omitempty"`
+}
+
+// MappingsConfig is used for the mappings section of the config
+// and for the mappings file with the choices saved by the program.
+type MappingsConfig struct {
+	Anime []Mapping `yaml:"anime" json:"anime"`
+	Manga []Mapping `yaml:"manga" json:"manga"`
+}
+
+// Add adds the mapping for the updater prefix, replacing the one with the same AniList ID.
+func (c *MappingsConfig) Add(prefix string, m Mapping) {
+	list := &c.Anime
+	if prefix == "Manga" {
+		list = &c.Manga
+	}
+
+	for i, existing := range *list {
+		if m.AnilistID > 0 && existing.AnilistID == m.AnilistID {
+			(*list)[i] = m
+			return
+		}
+	}
+
+	*list = append(*list, m)
+}
+
+// Remove removes the mapping of the AniList ID for the updater prefix, it reports whether it existed.
+func (c *MappingsConfig) Remove(prefix string, anilistID int) bool {
+	list := &c.Anime
+	if prefix == "Manga" {
+		list = &c.Manga
+	}
+
+	for i, existing := range *list {
+		if existing.AnilistID == anilistID {
+			*list = append((*list)[:i], (*list)[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultAnimeMappings skip the anime known to be missing on MAL, the mappings of the config
+// and the mappings file take precedence.
+var defaultAnimeMappings = []Mapping{
+	{Title: "Scott Pilgrim Takes Off", Skip: true},
+	{Title: "Bocchi the Rock! Recap Part 2", Skip: true},
+}
+
+type Mappings struct {
+	mu          sync.RWMutex
+	byAnilistID map[int]Mapping
+	byTitle     map[string]Mapping
+}
+
+// NewMappings merges the lists of mappings, later lists take precedence.
+func NewMappings(lists ...[]Mapping) *Mappings {
+	m := &Mappings{
+		byAnilistID: make(map[int]Mapping),
+		byTitle:     make(map[string]Mapping),
+	}
+	for _, mappings := range lists {
+		for _, mapping := range mappings {
+			m.Add(mapping)
+		}
+	}
+	return m
+}
+
+func (m *Mappings) Add(mapping Mapping) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mapping.AnilistID > 0 {
+		m.byAnilistID[mapping.AnilistID] = mapping
+	}
+	if mapping.Title != "" {
+		m.byTitle[strings.ToLower(mapping.Title)] = mapping
+	}
+}
+
+// Find returns the mapping for the source, the AniList ID takes precedence over the title.
+func (m *Mappings) Find(src Source) (Mapping, bool) {
+	if m == nil {
+		return Mapping{}, false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if mapping, ok := m.byAnilistID[src.GetAnilistID()]; ok {
+		return mapping, true
+	}
+	mapping, ok := m.byTitle[strings.ToLower(src.GetTitle())]
+	return mapping, ok
+}
+
+// Pinned reports whether a mapping pins an entry to the MAL ID.
+func (m *Mappings) Pinned(id TargetID) bool {
+	if m == nil || id <= 0 {
+		return false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, mapping := range m.byAnilistID {
+		if TargetID(mapping.MalID) == id {
+			return true
+		}
+	}
+	for _, mapping := range m.byTitle {
+		if TargetID(mapping.MalID) == id {
+			return true
+		}
+	}
+	return false
+}
+
+func readMappingsFile(path string) (*MappingsConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &MappingsConfig{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var mappings MappingsConfig
+	if err := json.NewDecoder(file).Decode(&mappings); err != nil {
+		return nil, err
+	}
+
+	return &mappings, nil
+}
+
+func writeMappingsFile(path string, mappings *MappingsConfig) error {
+	if err := createDirIfNotExists(path); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(mappings)
+}
@@ -3,9 +3,10 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net/url"
-	"time"
+	"strings"
 
 	"github.com/nstratos/go-myanimelist/mal"
 	"golang.org/x/oauth2"
@@ -16,7 +17,8 @@ var errEmptyMalID = errors.New("mal id is empty")
 var animeFields = mal.Fields{
 	"alternative_titles",
 	"num_episodes",
-	"my_list_status",
+	"media_type",
+	"my_list_status{num_times_rewatched,rewatch_value,priority,comments,tags}",
 	"start_season",
 }
 
@@ -24,7 +26,8 @@ var mangaFields = mal.Fields{
 	"alternative_titles",
 	"num_volumes",
 	"num_chapters",
-	"my_list_status",
+	"media_type",
+	"my_list_status{num_times_reread,reread_value,priority,comments,tags}",
 	"start_date",
 }
 
@@ -34,13 +37,23 @@ type MyAnimeListClient struct {
 	username string
 }
 
-func NewMyAnimeListClient(ctx context.Context, oauth *OAuth, username string) (*MyAnimeListClient, error) {
-	httpClient := oauth2.NewClient(ctx, oauth.TokenSource())
-	httpClient.Timeout = 10 * time.Minute
+func NewMyAnimeListClient(ctx context.Context, oauth *OAuth, config SiteConfig, httpConfig HTTPConfig) (*MyAnimeListClient, error) {
+	httpClient, err := newOAuthHTTPClient(ctx, oauth, httpConfig, "MyAnimeList", malRequestsPerMinute)
+	if err != nil {
+		return nil, err
+	}
 
 	client := mal.NewClient(httpClient)
+	if config.APIURL != "" {
+		// relative paths of the API are resolved against the base URL, so it must end with a slash
+		baseURL, err := url.Parse(strings.TrimSuffix(config.APIURL, "/") + "/")
+		if err != nil {
+			return nil, fmt.Errorf("invalid mal api url: %w", err)
+		}
+		client.BaseURL = baseURL
+	}
 
-	return &MyAnimeListClient{c: client, username: username}, nil
+	return &MyAnimeListClient{c: client, username: config.Username}, nil
 }
 
 func (c *MyAnimeListClient) GetUserAnimeList(ctx context.Context) ([]mal.UserAnime, error) {
@@ -151,13 +164,45 @@ func (c *MyAnimeListClient) UpdateMangaByIDAndOptions(ctx context.Context, id in
 	return nil
 }
 
+// GetUserName returns the name of the authorized user.
+func (c *MyAnimeListClient) GetUserName(ctx context.Context) (string, error) {
+	u, _, err := c.c.User.MyInfo(ctx)
+	if err != nil {
+		return "", err
+	}
+	return u.Name, nil
+}
+
 func NewMyAnimeListOAuth(ctx context.Context, config Config) (*OAuth, error) {
+	oauthMAL, err := newMyAnimeListOAuth(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if oauthMAL.NeedInit() {
+		if err := authorize(ctx, oauthMAL, config.OAuth); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Println("Token already set, no need to start server")
+	}
+
+	return oauthMAL, nil
+}
+
+// newMyAnimeListOAuth loads the MyAnimeList token without starting the authorization flow.
+func newMyAnimeListOAuth(ctx context.Context, config Config) (*OAuth, error) {
+	ctx, err := withHTTPClient(ctx, config.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
 	code := url.QueryEscape(randHttpParamString(43))
 
-	oauthMAL, err := NewOAuth(
+	return NewOAuth(
 		ctx,
 		config.MyAnimeList,
-		config.OAuth.RedirectURI,
+		config.MyAnimeList.redirectURI(config.OAuth),
 		"myanimelist",
 		[]oauth2.AuthCodeOption{
 			oauth2.SetAuthURLParam("code_challenge", code),
@@ -166,15 +211,94 @@ func NewMyAnimeListOAuth(ctx context.Context, config Config) (*OAuth, error) {
 		},
 		config.TokenFilePath,
 	)
+}
+
+type malAnimeTracker struct {
+	c *MyAnimeListClient
+}
+
+func (t *malAnimeTracker) Name() string { return "MAL" }
+
+func (t *malAnimeTracker) GetList(ctx context.Context) ([]Entry, error) {
+	list, err := t.c.GetUserAnimeList(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error getting user anime list from mal: %w", err)
 	}
+	return newEntriesFromAnimes(newAnimesFromMalUserAnimes(list)), nil
+}
 
-	if oauthMAL.NeedInit() {
-		getToken(ctx, oauthMAL, config.OAuth.Port)
-	} else {
-		log.Println("Token already set, no need to start server")
+func (t *malAnimeTracker) GetByID(ctx context.Context, id TargetID) (Target, error) {
+	resp, err := t.c.GetAnimeByID(ctx, int(id))
+	if err != nil {
+		return nil, fmt.Errorf("error getting anime by id: %w", err)
 	}
+	ani, err := newAnimeFromMalAnime(*resp)
+	if err != nil {
+		return nil, fmt.Errorf("error creating anime from mal anime: %w", err)
+	}
+	return ani, nil
+}
 
-	return oauthMAL, nil
+func (t *malAnimeTracker) Search(ctx context.Context, name string) ([]Target, error) {
+	resp, err := t.c.GetAnimesByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting anime by name: %w", err)
+	}
+	return newTargetsFromAnimes(newAnimesFromMalAnimes(resp)), nil
+}
+
+func (t *malAnimeTracker) Update(ctx context.Context, id TargetID, src Source) error {
+	a, ok := src.(Anime)
+	if !ok {
+		return fmt.Errorf("source is not an anime")
+	}
+	if err := t.c.UpdateAnimeByIDAndOptions(ctx, int(id), a.GetUpdateOptions()); err != nil {
+		return fmt.Errorf("error updating anime by id and options: %w", err)
+	}
+	return nil
+}
+
+type malMangaTracker struct {
+	c *MyAnimeListClient
+}
+
+func (t *malMangaTracker) Name() string { return "MAL" }
+
+func (t *malMangaTracker) GetList(ctx context.Context) ([]Entry, error) {
+	list, err := t.c.GetUserMangaList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user manga list from mal: %w", err)
+	}
+	return newEntriesFromMangas(newMangasFromMalUserMangas(list)), nil
+}
+
+func (t *malMangaTracker) GetByID(ctx context.Context, id TargetID) (Target, error) {
+	resp, err := t.c.GetMangaByID(ctx, int(id))
+	if err != nil {
+		return nil, fmt.Errorf("error getting manga by id: %w", err)
+	}
+	m, err := newMangaFromMalManga(*resp)
+	if err != nil {
+		return nil, fmt.Errorf("error creating manga from mal manga: %w", err)
+	}
+	return m, nil
+}
+
+func (t *malMangaTracker) Search(ctx context.Context, name string) ([]Target, error) {
+	resp, err := t.c.GetMangasByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting manga by name: %w", err)
+	}
+	return newTargetsFromMangas(newMangasFromMalMangas(resp)), nil
+}
+
+func (t *malMangaTracker) Update(ctx context.Context, id TargetID, src Source) error {
+	m, ok := src.(Manga)
+	if !ok {
+		return fmt.Errorf("source is not a manga")
+	}
+	if err := t.c.UpdateMangaByIDAndOptions(ctx, int(id), m.GetUpdateOptions()); err != nil {
+		return fmt.Errorf("error updating manga by id and options: %w", err)
+	}
+	return nil
 }
@@ -28,6 +28,7 @@ type OAuth struct {
 	siteName        string
 	authCodeOptions []oauth2.AuthCodeOption
 	tokenFilePath   string
+	state           string
 	ctx             context.Context
 
 	Config *oauth2.Config
@@ -62,6 +63,7 @@ func NewOAuth(
 		siteName:        siteName,
 		authCodeOptions: authCodeOptions,
 		tokenFilePath:   tokenFilePath,
+		state:           randHttpParamString(16),
 		ctx:             ctx,
 	}
 
@@ -71,10 +73,15 @@ func NewOAuth(
 }
 
 func (oauth *OAuth) GetAuthURL() string {
-	return oauth.Config.AuthCodeURL("state", oauth.authCodeOptions...)
+	return oauth.Config.AuthCodeURL(oauth.state, oauth.authCodeOptions...)
 }
 
 func (oauth *OAuth) ExchangeToken(ctx context.Context, code string) error {
+	// the token request goes through the configured HTTP client, as the refreshes do
+	if c, ok := oauth.ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+	}
+
 	token, err := oauth.Config.Exchange(ctx, code, oauth.authCodeOptions...)
 	if err != nil {
 		return err
@@ -83,6 +90,24 @@ func (oauth *OAuth) ExchangeToken(ctx context.Context, code string) error {
 	return oauth.saveTokenToFile()
 }
 
+// PasswordToken requests the token with the resource owner password grant and saves it.
+func (oauth *OAuth) PasswordToken(ctx context.Context, username, password string) error {
+	if username == "" || password == "" {
+		return fmt.Errorf("username and password are required for %s", oauth.siteName)
+	}
+
+	if c, ok := oauth.ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+	}
+
+	token, err := oauth.Config.PasswordCredentialsToken(ctx, username, password)
+	if err != nil {
+		return err
+	}
+	oauth.token = token
+	return oauth.saveTokenToFile()
+}
+
 func (oauth *OAuth) TokenSource() oauth2.TokenSource {
 	return oauth2.ReuseTokenSourceWithExpiry(oauth.token, oauth, 24*time.Hour)
 }
@@ -112,6 +137,27 @@ func (oauth *OAuth) NeedInit() bool {
 	return oauth.token == nil
 }
 
+// Expiry returns the expiry time of the access token, zero if there is no token.
+func (oauth *OAuth) Expiry() time.Time {
+	if oauth.token == nil {
+		return time.Time{}
+	}
+	return oauth.token.Expiry
+}
+
+// DeleteToken forgets the token and removes it from the token file.
+func (oauth *OAuth) DeleteToken() error {
+	tokenFile, err := readTokenFile(oauth.tokenFilePath)
+	if err != nil {
+		return err
+	}
+
+	oauth.token = nil
+	delete(tokenFile.Tokens, oauth.siteName)
+
+	return writeTokenFile(oauth.tokenFilePath, tokenFile)
+}
+
 func (oauth *OAuth) loadTokenFromFile() {
 	tokenFile, err := readTokenFile(oauth.tokenFilePath)
 	if err != nil {
@@ -186,6 +232,11 @@ func startServer(ctx context.Context, oauth *OAuth, port string, done chan<- boo
 		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 		defer cancel()
 
+		if r.URL.Query().Get("state") != oauth.state {
+			http.Error(w, "State mismatch", http.StatusBadRequest)
+			return
+		}
+
 		code := r.URL.Query().Get("code")
 
 		err := oauth.ExchangeToken(ctx, code)
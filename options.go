@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Options are the command line options of the sync and diff commands.
+type Options struct {
+	Force       bool
+	DryRun      bool
+	Verbose     bool
+	Manga       bool // sync manga instead of anime
+	All         bool // sync anime and manga
+	ReportPath  string
+	Watch       bool
+	Interval    time.Duration
+	Direction   SyncDirection
+	Favourites  bool
+	Workers     int
+	Interactive bool
+	Ignore      IgnoreConfig
+	// Orphans is the format of the orphan report printed after the run: table or json, empty if disabled.
+	Orphans string
+}
+
+// DefaultOptions returns the options used when no flags are given.
+func DefaultOptions() Options {
+	return Options{
+		Interval:  30 * time.Minute,
+		Direction: DirectionAnilistToMal,
+		Workers:   4,
+	}
+}
+
+func (o Options) Validate() error {
+	if o.Interval <= 0 {
+		return fmt.Errorf("interval must be positive: %s", o.Interval)
+	}
+	if o.Workers < 1 {
+		return fmt.Errorf("workers must be positive: %d", o.Workers)
+	}
+	if !o.Direction.Valid() {
+		return fmt.Errorf("unknown sync direction: %s", o.Direction)
+	}
+	if o.Orphans != "" && o.Orphans != "table" && o.Orphans != "json" {
+		return fmt.Errorf("unknown orphans format: %s", o.Orphans)
+	}
+	return nil
+}
+
+// syncAnime and syncManga tell which lists are synced.
+func (o Options) syncAnime() bool { return !o.Manga || o.All }
+func (o Options) syncManga() bool { return o.Manga || o.All }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+)
+
+// OrphanEntry is a MAL entry that no AniList entry was matched with.
+type OrphanEntry struct {
+	Type   string  `json:"type"`
+	MalID  int     `json:"mal_id"`
+	Title  string  `json:"title"`
+	Status string  `json:"status"`
+	Score  float64 `json:"score"`
+}
+
+// Orphans collects the targets without source, it never changes any list.
+type Orphans struct {
+	mu      sync.Mutex
+	Entries []OrphanEntry `json:"entries"`
+}
+
+func NewOrphans() *Orphans {
+	return &Orphans{Entries: make([]OrphanEntry, 0)}
+}
+
+func (o *Orphans) Add(prefix string, tgt Target) {
+	if o == nil {
+		return
+	}
+
+	e := OrphanEntry{
+		Type:  prefix,
+		MalID: int(tgt.GetTargetID()),
+		Title: targetTitle(tgt),
+	}
+	switch t := tgt.(type) {
+	case Anime:
+		e.Status, e.Score = string(t.Status), t.Score
+	case Manga:
+		e.Status, e.Score = string(t.Status), t.Score
+	}
+
+	o.mu.Lock()
+	o.Entries = append(o.Entries, e)
+	o.mu.Unlock()
+}
+
+// Write writes the entries as a table or, with json format, as JSON.
+func (o *Orphans) Write(w io.Writer, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(o)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tMAL ID\tTITLE\tSTATUS\tSCORE")
+	for _, e := range o.Entries {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%g\n", e.Type, e.MalID, e.Title, e.Status, e.Score)
+	}
+	return tw.Flush()
+}
+
+func targetTitle(tgt Target) string {
+	if s, ok := tgt.(Source); ok {
+		return s.GetTitle()
+	}
+	return tgt.String()
+}
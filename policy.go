@@ -0,0 +1,102 @@
+package main
+
+import "fmt"
+
+// SyncMode tells how a field of the source is written to the target.
+type SyncMode string
+
+const (
+	SyncModeOverwrite   SyncMode = "overwrite"
+	SyncModeOnlyIfEmpty SyncMode = "only-if-empty"
+	SyncModeNever       SyncMode = "never"
+)
+
+func (m SyncMode) Valid() bool {
+	switch m {
+	case "", SyncModeOverwrite, SyncModeOnlyIfEmpty, SyncModeNever:
+		return true
+	default:
+		return false
+	}
+}
+
+// keep reports whether the target value stays as is.
+func (m SyncMode) keep(tgtEmpty bool) bool {
+	switch m {
+	case SyncModeNever:
+		return true
+	case SyncModeOnlyIfEmpty:
+		return !tgtEmpty
+	default:
+		return false
+	}
+}
+
+type SyncPoliciesConfig struct {
+	Anime SyncPolicy `yaml:"anime"`
+	Manga SyncPolicy `yaml:"manga"`
+}
+
+// SyncPolicy sets the mode of every synced field, empty mode is overwrite.
+type SyncPolicy struct {
+	Status   SyncMode `yaml:"status"`
+	Score    SyncMode `yaml:"score"`
+	Progress SyncMode `yaml:"progress"`
+	Volumes  SyncMode `yaml:"volumes"`
+	Dates    SyncMode `yaml:"dates"`
+	Notes    SyncMode `yaml:"notes"`
+	Rewatch  SyncMode `yaml:"rewatch_count"`
+	// ClearNotes writes empty notes too, otherwise an empty source note never clears the target notes.
+	ClearNotes bool `yaml:"clear_notes"`
+}
+
+func (p SyncPolicy) modes() map[string]SyncMode {
+	return map[string]SyncMode{
+		"status":        p.Status,
+		"score":         p.Score,
+		"progress":      p.Progress,
+		"volumes":       p.Volumes,
+		"dates":         p.Dates,
+		"notes":         p.Notes,
+		"rewatch_count": p.Rewatch,
+	}
+}
+
+func (p SyncPolicy) Validate() error {
+	for field, mode := range p.modes() {
+		if !mode.Valid() {
+			return fmt.Errorf("unknown sync mode for %s: %s", field, mode)
+		}
+	}
+	return nil
+}
+
+// NeedsTarget reports whether the target has to be fetched before updating it.
+func (p SyncPolicy) NeedsTarget() bool {
+	if p.ClearNotes {
+		return true
+	}
+	for _, mode := range p.modes() {
+		if mode != "" && mode != SyncModeOverwrite {
+			return true
+		}
+	}
+	return false
+}
+
+// policySource is a source that can take the target values of the fields it must not write.
+type policySource interface {
+	ApplyPolicy(p SyncPolicy, tgt Target) Source
+}
+
+// applyPolicy returns the source with the unmanaged fields copied from the target,
+// so they are neither compared nor changed.
+func (u *Updater) applyPolicy(src Source, tgt Target) Source {
+	if !u.Policy.NeedsTarget() {
+		return src
+	}
+	if ps, ok := src.(policySource); ok {
+		return ps.ApplyPolicy(u.Policy, tgt)
+	}
+	return src
+}
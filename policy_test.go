@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestEmptyNotesKeepTarget(t *testing.T) {
+	src := Anime{IDAnilist: 1, IDMal: 1, Status: StatusWatching, Progress: 3}
+	tgt := Anime{IDAnilist: -1, IDMal: 1, Status: StatusWatching, Progress: 3, Notes: "MAL comment"}
+
+	if !src.SameProgressWithTarget(tgt, t.Logf) {
+		t.Error("empty notes count as a change")
+	}
+	if _, ok := src.GetAnilistUpdateOptions()["notes"]; ok {
+		t.Error("empty notes are written")
+	}
+
+	cleared := src.ApplyPolicy(SyncPolicy{ClearNotes: true}, tgt).(Anime)
+	if cleared.SameProgressWithTarget(tgt, t.Logf) {
+		t.Error("notes cleared by the policy don't count as a change")
+	}
+	if notes, ok := cleared.GetAnilistUpdateOptions()["notes"]; !ok || notes != "" {
+		t.Errorf("got notes %v, want them cleared", notes)
+	}
+
+	kept := src.ApplyPolicy(SyncPolicy{Notes: SyncModeNever, ClearNotes: true}, tgt).(Anime)
+	if kept.Notes != tgt.Notes || !kept.SameProgressWithTarget(tgt, t.Logf) {
+		t.Errorf("got notes %q, want the target ones kept", kept.Notes)
+	}
+}
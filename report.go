@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type ReportAction string
+
+const (
+	ReportActionUpdated  ReportAction = "updated"
+	ReportActionSkipped  ReportAction = "skipped"
+	ReportActionIgnored  ReportAction = "ignored"
+	ReportActionNotFound ReportAction = "not-found"
+	ReportActionError    ReportAction = "error"
+	ReportActionDryRun   ReportAction = "dry-run"
+
+	ReportActionFavouriteAdded   ReportAction = "favourite-added"
+	ReportActionFavouriteRemoved ReportAction = "favourite-removed"
+)
+
+// ReportEntry is the outcome of processing a single source entry.
+type ReportEntry struct {
+	Type      string       `json:"type"`
+	AnilistID int          `json:"anilist_id"`
+	MalID     int          `json:"mal_id"`
+	Title     string       `json:"title"`
+	Action    ReportAction `json:"action"`
+	Diff      string       `json:"diff,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+type Report struct {
+	mu      sync.Mutex
+	Entries []ReportEntry `json:"entries"`
+}
+
+func NewReport() *Report {
+	return &Report{Entries: make([]ReportEntry, 0)}
+}
+
+func (r *Report) Add(e ReportEntry) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.Entries = append(r.Entries, e)
+	r.mu.Unlock()
+}
+
+// WriteFile writes the report as CSV if the path has .csv extension and as JSON otherwise.
+func (r *Report) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return r.writeCSV(file)
+	}
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func (r *Report) writeCSV(file *os.File) error {
+	w := csv.NewWriter(file)
+
+	if err := w.Write([]string{"type", "anilist_id", "mal_id", "title", "action", "diff", "error"}); err != nil {
+		return err
+	}
+
+	for _, e := range r.Entries {
+		err := w.Write([]string{
+			e.Type,
+			strconv.Itoa(e.AnilistID),
+			strconv.Itoa(e.MalID),
+			e.Title,
+			string(e.Action),
+			e.Diff,
+			e.Error,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/rl404/verniy"
+)
+
+type ScoreRounding string
+
+const (
+	ScoreRoundingNearest ScoreRounding = "round"
+	ScoreRoundingFloor   ScoreRounding = "floor"
+	ScoreRoundingCeil    ScoreRounding = "ceil"
+)
+
+func (r ScoreRounding) Valid() bool {
+	switch r {
+	case ScoreRoundingNearest, ScoreRoundingFloor, ScoreRoundingCeil:
+		return true
+	default:
+		return false
+	}
+}
+
+// point3Scores are the smiley scores in AniList's own 0-100 conversion.
+var point3Scores = map[float64]float64{1: 35, 2: 60, 3: 85}
+
+// ScoreConverter converts AniList scores in the user's score format to MAL's 0-10 integer scale.
+type ScoreConverter struct {
+	Format   verniy.ScoreFormat
+	Rounding ScoreRounding
+}
+
+func NewScoreConverter(format verniy.ScoreFormat, rounding ScoreRounding) (ScoreConverter, error) {
+	if rounding == "" {
+		rounding = ScoreRoundingNearest
+	}
+	if !rounding.Valid() {
+		return ScoreConverter{}, fmt.Errorf("unknown score rounding: %s", rounding)
+	}
+	return ScoreConverter{Format: format, Rounding: rounding}, nil
+}
+
+// ToMal returns the MAL score for the AniList score, 0 means not scored.
+// Scored entries are never rounded down to 0.
+func (c ScoreConverter) ToMal(score float64) float64 {
+	if score <= 0 {
+		return 0
+	}
+
+	var s float64
+	switch c.Format {
+	case verniy.ScoreFormatPoint100:
+		s = score / 10
+	case verniy.ScoreFormatPoint5:
+		s = score * 2
+	case verniy.ScoreFormatPoint3:
+		s = point3Scores[score] / 10
+	default: // POINT_10 and POINT_10_DECIMAL are already on MAL's scale
+		s = score
+	}
+
+	switch c.Rounding {
+	case ScoreRoundingFloor:
+		s = math.Floor(s)
+	case ScoreRoundingCeil:
+		s = math.Ceil(s)
+	default:
+		s = math.Round(s)
+	}
+
+	return math.Max(1, math.Min(10, s))
+}
+
+// FromMal returns the AniList score in the user's score format closest to the MAL score, 0 means not scored.
+// Its MAL score is the closest one ToMal can give, so converting it back and forth doesn't change it.
+func (c ScoreConverter) FromMal(score float64) float64 {
+	if score <= 0 {
+		return 0
+	}
+
+	steps, step := c.scale()
+	if steps == 0 {
+		return score
+	}
+
+	best := 0.0
+	bestMal, bestRaw := math.Inf(1), math.Inf(1)
+	for i := 1; i <= steps; i++ {
+		s := float64(i) * step
+		mal := math.Abs(c.ToMal(s) - score)
+		raw := math.Abs(float64(c.raw(s))/10 - score)
+		if mal < bestMal || (mal == bestMal && raw < bestRaw) {
+			best, bestMal, bestRaw = s, mal, raw
+		}
+	}
+	return best
+}
+
+// RawFromMal returns the AniList 0-100 raw score for the MAL score.
+func (c ScoreConverter) RawFromMal(score float64) int {
+	return c.raw(c.FromMal(score))
+}
+
+// Normalize returns the MAL score as it comes back after being stored on AniList.
+func (c ScoreConverter) Normalize(score float64) float64 {
+	if score <= 0 {
+		return 0
+	}
+	if steps, _ := c.scale(); steps == 0 {
+		return score
+	}
+	return c.ToMal(c.FromMal(score))
+}
+
+// SameScore compares the MAL scores as AniList stores them, the formats coarser than MAL's
+// would report a difference on every run otherwise.
+func (c ScoreConverter) SameScore(a, b float64) bool {
+	return a == b || c.Normalize(a) == c.Normalize(b)
+}
+
+// scale returns the number of scores of the format and the step between them, 0 for unknown formats.
+func (c ScoreConverter) scale() (int, float64) {
+	switch c.Format {
+	case verniy.ScoreFormatPoint100:
+		return 100, 1
+	case verniy.ScoreFormatPoint100Decimal:
+		return 100, 0.1
+	case verniy.ScoreFormatPoint10:
+		return 10, 1
+	case verniy.ScoreFormatPoint5:
+		return 5, 1
+	case verniy.ScoreFormatPoint3:
+		return 3, 1
+	default:
+		return 0, 0
+	}
+}
+
+// raw returns the AniList 0-100 raw score of the score in the user's format.
+func (c ScoreConverter) raw(score float64) int {
+	switch c.Format {
+	case verniy.ScoreFormatPoint100:
+		return int(math.Round(score))
+	case verniy.ScoreFormatPoint5:
+		return int(math.Round(score * 20))
+	case verniy.ScoreFormatPoint3:
+		return int(point3Scores[math.Round(score)])
+	default:
+		return int(math.Round(score * 10))
+	}
+}
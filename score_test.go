@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rl404/verniy"
+)
+
+var testScoreFormats = []verniy.ScoreFormat{
+	verniy.ScoreFormatPoint100,
+	verniy.ScoreFormatPoint100Decimal,
+	verniy.ScoreFormatPoint10,
+	verniy.ScoreFormatPoint5,
+	verniy.ScoreFormatPoint3,
+}
+
+func TestScoreConverterConverges(t *testing.T) {
+	for _, format := range testScoreFormats {
+		for _, rounding := range []ScoreRounding{ScoreRoundingNearest, ScoreRoundingFloor, ScoreRoundingCeil} {
+			c := ScoreConverter{Format: format, Rounding: rounding}
+
+			for mal := 1.0; mal <= 10; mal++ {
+				// a MAL score written to AniList and read back doesn't change anymore
+				stored := c.ToMal(c.FromMal(mal))
+				if !c.SameScore(mal, stored) {
+					t.Errorf("%s %s: MAL %g is stored as %g but compared as different", format, rounding, mal, stored)
+				}
+				if again := c.ToMal(c.FromMal(stored)); again != stored {
+					t.Errorf("%s %s: MAL %g is stored as %g, then as %g", format, rounding, mal, stored, again)
+				}
+			}
+		}
+	}
+}
+
+func TestScoreConverterFromMal(t *testing.T) {
+	tests := []struct {
+		format verniy.ScoreFormat
+		mal    float64
+		want   float64
+		raw    int
+	}{
+		{verniy.ScoreFormatPoint100, 7, 70, 70},
+		{verniy.ScoreFormatPoint100Decimal, 8, 8, 80},
+		{verniy.ScoreFormatPoint10, 6, 6, 60},
+		{verniy.ScoreFormatPoint5, 8, 4, 80},
+		{verniy.ScoreFormatPoint3, 6, 2, 60},
+		{verniy.ScoreFormatPoint3, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		c := ScoreConverter{Format: tt.format, Rounding: ScoreRoundingNearest}
+		if got := c.FromMal(tt.mal); got != tt.want {
+			t.Errorf("%s: FromMal(%g) = %g, want %g", tt.format, tt.mal, got, tt.want)
+		}
+		if got := c.RawFromMal(tt.mal); got != tt.raw {
+			t.Errorf("%s: RawFromMal(%g) = %d, want %d", tt.format, tt.mal, got, tt.raw)
+		}
+	}
+}
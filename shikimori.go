@@ -0,0 +1,645 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	shikimoriDefaultURL       = "https://shikimori.one"
+	shikimoriDefaultUserAgent = "anilist-mal-sync"
+	// shikimoriOOBRedirectURI makes Shikimori show the authorization code instead of redirecting.
+	shikimoriOOBRedirectURI = "urn:ietf:wg:oauth:2.0:oob"
+
+	shikimoriRequestsPerMinute = 90
+	shikimoriRatesLimit        = 5000
+	shikimoriSearchLimit       = 5
+)
+
+// ShikimoriClient talks to the Shikimori API, its anime and manga IDs are the MAL ones.
+type ShikimoriClient struct {
+	http    *http.Client
+	baseURL string
+
+	mu     sync.Mutex
+	userID int
+}
+
+func NewShikimoriClient(ctx context.Context, oauth *OAuth, config SiteConfig, httpConfig HTTPConfig) (*ShikimoriClient, error) {
+	httpClient, err := newOAuthHTTPClient(ctx, oauth, shikimoriHTTPConfig(httpConfig), "Shikimori", shikimoriRequestsPerMinute)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := shikimoriDefaultURL
+	if config.APIURL != "" {
+		baseURL = strings.TrimSuffix(config.APIURL, "/")
+	}
+
+	return &ShikimoriClient{http: httpClient, baseURL: baseURL}, nil
+}
+
+// shikimoriHTTPConfig sets the User-Agent, Shikimori rejects requests without the application name.
+func shikimoriHTTPConfig(c HTTPConfig) HTTPConfig {
+	if c.UserAgent == "" {
+		c.UserAgent = shikimoriDefaultUserAgent
+	}
+	return c
+}
+
+func NewShikimoriOAuth(ctx context.Context, config Config) (*OAuth, error) {
+	oauthShikimori, err := newShikimoriOAuth(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if oauthShikimori.NeedInit() {
+		if err := authorize(ctx, oauthShikimori, config.OAuth); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Println("Token already set, no need to start server")
+	}
+
+	return oauthShikimori, nil
+}
+
+// newShikimoriOAuth loads the Shikimori token without starting the authorization flow.
+func newShikimoriOAuth(ctx context.Context, config Config) (*OAuth, error) {
+	ctx, err := withHTTPClient(ctx, shikimoriHTTPConfig(config.HTTP))
+	if err != nil {
+		return nil, err
+	}
+
+	site := config.Shikimori
+	if site.AuthURL == "" {
+		site.AuthURL = shikimoriDefaultURL + "/oauth/authorize"
+	}
+	if site.TokenURL == "" {
+		site.TokenURL = shikimoriDefaultURL + "/oauth/token"
+	}
+
+	return NewOAuth(
+		ctx,
+		site,
+		site.redirectURI(config.OAuth),
+		"shikimori",
+		[]oauth2.AuthCodeOption{
+			oauth2.SetAuthURLParam("scope", "user_rates"),
+		},
+		config.TokenFilePath,
+	)
+}
+
+type shikimoriMedia struct {
+	ID       int      `json:"id"`
+	Name     string   `json:"name"`
+	Russian  string   `json:"russian"`
+	English  []string `json:"english"`
+	Japanese []string `json:"japanese"`
+	Kind     string   `json:"kind"`
+	Episodes int      `json:"episodes"`
+	Volumes  int      `json:"volumes"`
+	Chapters int      `json:"chapters"`
+	AiredOn  string   `json:"aired_on"`
+
+	// UserRate is the authorized user's rate, only in the media details.
+	UserRate *shikimoriUserRate `json:"user_rate"`
+}
+
+type shikimoriUserRate struct {
+	ID        int       `json:"id"`
+	Score     int       `json:"score"`
+	Status    string    `json:"status"`
+	Episodes  int       `json:"episodes"`
+	Volumes   int       `json:"volumes"`
+	Chapters  int       `json:"chapters"`
+	Rewatches int       `json:"rewatches"`
+	Text      *string   `json:"text"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// shikimoriRate is a user rate with its anime or manga, as in the user's lists.
+type shikimoriRate struct {
+	shikimoriUserRate
+
+	Anime *shikimoriMedia `json:"anime"`
+	Manga *shikimoriMedia `json:"manga"`
+}
+
+func (r shikimoriRate) media() *shikimoriMedia {
+	if r.Anime != nil {
+		return r.Anime
+	}
+	return r.Manga
+}
+
+// GetRates returns the user's rates of the kind, anime or manga.
+func (c *ShikimoriClient) GetRates(ctx context.Context, kind string) ([]shikimoriRate, error) {
+	userID, err := c.getUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []shikimoriRate
+	for page := 1; ; page++ {
+		path := fmt.Sprintf("/api/users/%d/%s_rates?", userID, kind) + url.Values{
+			"limit": {strconv.Itoa(shikimoriRatesLimit)},
+			"page":  {strconv.Itoa(page)},
+		}.Encode()
+
+		var list []shikimoriRate
+		if err := c.do(ctx, http.MethodGet, path, nil, &list); err != nil {
+			return nil, err
+		}
+
+		// a page has one more entry than the limit when there are more pages
+		if len(list) <= shikimoriRatesLimit {
+			return append(rates, list...), nil
+		}
+		rates = append(rates, list[:shikimoriRatesLimit]...)
+	}
+}
+
+// GetMedia returns the anime or manga with the user's rate.
+func (c *ShikimoriClient) GetMedia(ctx context.Context, kind string, id int) (*shikimoriMedia, error) {
+	var media shikimoriMedia
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/%ss/%d", kind, id), nil, &media); err != nil {
+		return nil, err
+	}
+	return &media, nil
+}
+
+func (c *ShikimoriClient) Search(ctx context.Context, kind, name string) ([]shikimoriMedia, error) {
+	path := fmt.Sprintf("/api/%ss?", kind) + url.Values{
+		"search": {name},
+		"limit":  {strconv.Itoa(shikimoriSearchLimit)},
+	}.Encode()
+
+	var medias []shikimoriMedia
+	if err := c.do(ctx, http.MethodGet, path, nil, &medias); err != nil {
+		return nil, err
+	}
+	return medias, nil
+}
+
+// SaveRate updates the user's rate of the media or creates it.
+func (c *ShikimoriClient) SaveRate(ctx context.Context, kind string, media shikimoriMedia, rate map[string]any) error {
+	if media.UserRate != nil {
+		path := fmt.Sprintf("/api/v2/user_rates/%d", media.UserRate.ID)
+		return c.do(ctx, http.MethodPatch, path, map[string]any{"user_rate": rate}, nil)
+	}
+
+	userID, err := c.getUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	created := make(map[string]any, len(rate)+3)
+	for k, v := range rate {
+		created[k] = v
+	}
+	created["user_id"] = userID
+	created["target_id"] = media.ID
+	created["target_type"] = shikimoriTargetType(kind)
+
+	return c.do(ctx, http.MethodPost, "/api/v2/user_rates", map[string]any{"user_rate": created}, nil)
+}
+
+// GetUserName returns the name of the authorized user.
+func (c *ShikimoriClient) GetUserName(ctx context.Context) (string, error) {
+	var user struct {
+		Nickname string `json:"nickname"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/users/whoami", nil, &user); err != nil {
+		return "", err
+	}
+	return user.Nickname, nil
+}
+
+func (c *ShikimoriClient) getUserID(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.userID > 0 {
+		return c.userID, nil
+	}
+
+	var user struct {
+		ID int `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/users/whoami", nil, &user); err != nil {
+		return 0, err
+	}
+	if user.ID == 0 {
+		return 0, errors.New("shikimori: no authorized user")
+	}
+
+	c.userID = user.ID
+	return c.userID, nil
+}
+
+func (c *ShikimoriClient) do(ctx context.Context, method, path string, body, v any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var errResp struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(data, &errResp); err == nil && errResp.Message != "" {
+			return fmt.Errorf("shikimori: %d: %s", resp.StatusCode, errResp.Message)
+		}
+		return fmt.Errorf("shikimori: unexpected status code: %d", resp.StatusCode)
+	}
+
+	if v == nil || len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func shikimoriTargetType(kind string) string {
+	if kind == "manga" {
+		return "Manga"
+	}
+	return "Anime"
+}
+
+func (m shikimoriMedia) titles() (en, jp, romaji string) {
+	en, romaji = m.Name, m.Name
+	if len(m.English) > 0 && m.English[0] != "" {
+		en = m.English[0]
+	}
+	if len(m.Japanese) > 0 {
+		jp = m.Japanese[0]
+	}
+	return en, jp, romaji
+}
+
+func (r *shikimoriUserRate) text() string {
+	if r.Text == nil {
+		return ""
+	}
+	return *r.Text
+}
+
+func newAnimeFromShikimori(media shikimoriMedia, rate *shikimoriUserRate) Anime {
+	en, jp, romaji := media.titles()
+
+	a := Anime{
+		NumEpisodes: media.Episodes,
+		IDAnilist:   -1,
+		IDMal:       media.ID,
+		SeasonYear:  parseYear(media.AiredOn),
+		MediaType:   media.Kind,
+		Status:      StatusUnknown,
+		TitleEN:     en,
+		TitleJP:     jp,
+		TitleRomaji: romaji,
+	}
+
+	if rate != nil {
+		a.Status = mapShikimoriStatusToStatus(rate.Status)
+		a.Progress = rate.Episodes
+		a.Score = float64(rate.Score)
+		a.UpdatedAt = rate.UpdatedAt
+		a.IsRewatching = rate.Status == "rewatching"
+		a.NumTimesRewatched = rate.Rewatches
+		a.Notes = rate.text()
+	}
+
+	return a
+}
+
+func newMangaFromShikimori(media shikimoriMedia, rate *shikimoriUserRate) Manga {
+	en, jp, romaji := media.titles()
+
+	m := Manga{
+		IDAnilist:   -1,
+		IDMal:       media.ID,
+		Chapters:    media.Chapters,
+		Volumes:     media.Volumes,
+		Year:        parseYear(media.AiredOn),
+		MediaType:   media.Kind,
+		Status:      MangaStatusUnknown,
+		TitleEN:     en,
+		TitleJP:     jp,
+		TitleRomaji: romaji,
+	}
+
+	if rate != nil {
+		m.Status = mapShikimoriStatusToMangaStatus(rate.Status)
+		m.Progress = rate.Chapters
+		m.ProgressVolumes = rate.Volumes
+		m.Score = float64(rate.Score)
+		m.UpdatedAt = rate.UpdatedAt
+		m.IsRereading = rate.Status == "rewatching"
+		m.NumTimesReread = rate.Rewatches
+		m.Notes = rate.text()
+	}
+
+	return m
+}
+
+func mapShikimoriStatusToStatus(s string) Status {
+	switch s {
+	case "watching":
+		return StatusWatching
+	case "completed", "rewatching":
+		return StatusCompleted // MAL keeps rewatching entries completed with the rewatching flag
+	case "on_hold":
+		return StatusOnHold
+	case "dropped":
+		return StatusDropped
+	case "planned":
+		return StatusPlanToWatch
+	default:
+		return StatusUnknown
+	}
+}
+
+func mapShikimoriStatusToMangaStatus(s string) MangaStatus {
+	switch s {
+	case "watching":
+		return MangaStatusReading
+	case "completed", "rewatching":
+		return MangaStatusCompleted
+	case "on_hold":
+		return MangaStatusOnHold
+	case "dropped":
+		return MangaStatusDropped
+	case "planned":
+		return MangaStatusPlanToRead
+	default:
+		return MangaStatusUnknown
+	}
+}
+
+func (s Status) GetShikimoriStatus() (string, error) {
+	switch s {
+	case StatusWatching:
+		return "watching", nil
+	case StatusCompleted:
+		return "completed", nil
+	case StatusOnHold:
+		return "on_hold", nil
+	case StatusDropped:
+		return "dropped", nil
+	case StatusPlanToWatch:
+		return "planned", nil
+	default:
+		return "", errStatusUnknown
+	}
+}
+
+// GetShikimoriStatus returns the Shikimori status, which uses the anime names for manga too.
+func (s MangaStatus) GetShikimoriStatus() (string, error) {
+	switch s {
+	case MangaStatusReading:
+		return "watching", nil
+	case MangaStatusCompleted:
+		return "completed", nil
+	case MangaStatusOnHold:
+		return "on_hold", nil
+	case MangaStatusDropped:
+		return "dropped", nil
+	case MangaStatusPlanToRead:
+		return "planned", nil
+	default:
+		return "", errStatusUnknown
+	}
+}
+
+func (a Anime) GetShikimoriRate() (map[string]any, error) {
+	st, err := a.Status.GetShikimoriStatus()
+	if err != nil {
+		return nil, err
+	}
+	if a.IsRewatching {
+		st = "rewatching"
+	}
+
+	rate := map[string]any{
+		"status":    st,
+		"score":     int(a.Score),
+		"episodes":  a.Progress,
+		"rewatches": a.NumTimesRewatched,
+	}
+	if a.writesNotes() {
+		rate["text"] = a.Notes
+	}
+	return rate, nil
+}
+
+func (m Manga) GetShikimoriRate() (map[string]any, error) {
+	st, err := m.Status.GetShikimoriStatus()
+	if err != nil {
+		return nil, err
+	}
+	if m.IsRereading {
+		st = "rewatching"
+	}
+
+	rate := map[string]any{
+		"status":    st,
+		"score":     int(m.Score),
+		"chapters":  m.Progress,
+		"volumes":   m.ProgressVolumes,
+		"rewatches": m.NumTimesReread,
+	}
+	if m.writesNotes() {
+		rate["text"] = m.Notes
+	}
+	return rate, nil
+}
+
+type shikimoriAnimeTracker struct {
+	c *ShikimoriClient
+}
+
+func (t *shikimoriAnimeTracker) Name() string { return "Shikimori" }
+
+func (t *shikimoriAnimeTracker) GetList(ctx context.Context) ([]Entry, error) {
+	rates, err := t.c.GetRates(ctx, "anime")
+	if err != nil {
+		return nil, fmt.Errorf("error getting user anime list from shikimori: %w", err)
+	}
+
+	res := make([]Entry, 0, len(rates))
+	for _, rate := range rates {
+		if media := rate.media(); media != nil {
+			res = append(res, newAnimeFromShikimori(*media, &rate.shikimoriUserRate))
+		}
+	}
+	return res, nil
+}
+
+func (t *shikimoriAnimeTracker) GetByID(ctx context.Context, id TargetID) (Target, error) {
+	media, err := t.c.GetMedia(ctx, "anime", int(id))
+	if err != nil {
+		return nil, fmt.Errorf("error getting anime by id: %w", err)
+	}
+	return newAnimeFromShikimori(*media, media.UserRate), nil
+}
+
+func (t *shikimoriAnimeTracker) Search(ctx context.Context, name string) ([]Target, error) {
+	medias, err := t.c.Search(ctx, "anime", name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting anime by name: %w", err)
+	}
+
+	res := make([]Target, 0, len(medias))
+	for _, media := range medias {
+		res = append(res, newAnimeFromShikimori(media, nil))
+	}
+	return res, nil
+}
+
+func (t *shikimoriAnimeTracker) Update(ctx context.Context, id TargetID, src Source) error {
+	a, ok := src.(Anime)
+	if !ok {
+		return fmt.Errorf("source is not an anime")
+	}
+
+	rate, err := a.GetShikimoriRate()
+	if err != nil {
+		return fmt.Errorf("error getting shikimori rate: %w", err)
+	}
+
+	media, err := t.c.GetMedia(ctx, "anime", int(id))
+	if err != nil {
+		return fmt.Errorf("error getting anime by id: %w", err)
+	}
+
+	if err := t.c.SaveRate(ctx, "anime", *media, rate); err != nil {
+		return fmt.Errorf("error saving anime rate: %w", err)
+	}
+	return nil
+}
+
+// AdaptSource drops the fields Shikimori doesn't keep: private flag and the MAL only fields.
+func (t *shikimoriAnimeTracker) AdaptSource(src Source) Source {
+	a, ok := src.(Anime)
+	if !ok {
+		return src
+	}
+	a.Private = false
+	a.Tags, a.ManagedTags, a.Priority, a.RewatchValue = nil, nil, nil, nil
+	return a
+}
+
+type shikimoriMangaTracker struct {
+	c *ShikimoriClient
+}
+
+func (t *shikimoriMangaTracker) Name() string { return "Shikimori" }
+
+func (t *shikimoriMangaTracker) GetList(ctx context.Context) ([]Entry, error) {
+	rates, err := t.c.GetRates(ctx, "manga")
+	if err != nil {
+		return nil, fmt.Errorf("error getting user manga list from shikimori: %w", err)
+	}
+
+	res := make([]Entry, 0, len(rates))
+	for _, rate := range rates {
+		if media := rate.media(); media != nil {
+			res = append(res, newMangaFromShikimori(*media, &rate.shikimoriUserRate))
+		}
+	}
+	return res, nil
+}
+
+func (t *shikimoriMangaTracker) GetByID(ctx context.Context, id TargetID) (Target, error) {
+	media, err := t.c.GetMedia(ctx, "manga", int(id))
+	if err != nil {
+		return nil, fmt.Errorf("error getting manga by id: %w", err)
+	}
+	return newMangaFromShikimori(*media, media.UserRate), nil
+}
+
+func (t *shikimoriMangaTracker) Search(ctx context.Context, name string) ([]Target, error) {
+	medias, err := t.c.Search(ctx, "manga", name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting manga by name: %w", err)
+	}
+
+	res := make([]Target, 0, len(medias))
+	for _, media := range medias {
+		res = append(res, newMangaFromShikimori(media, nil))
+	}
+	return res, nil
+}
+
+func (t *shikimoriMangaTracker) Update(ctx context.Context, id TargetID, src Source) error {
+	m, ok := src.(Manga)
+	if !ok {
+		return fmt.Errorf("source is not a manga")
+	}
+
+	rate, err := m.GetShikimoriRate()
+	if err != nil {
+		return fmt.Errorf("error getting shikimori rate: %w", err)
+	}
+
+	media, err := t.c.GetMedia(ctx, "manga", int(id))
+	if err != nil {
+		return fmt.Errorf("error getting manga by id: %w", err)
+	}
+
+	if err := t.c.SaveRate(ctx, "manga", *media, rate); err != nil {
+		return fmt.Errorf("error saving manga rate: %w", err)
+	}
+	return nil
+}
+
+// AdaptSource drops the fields Shikimori doesn't keep: private flag and the MAL only fields.
+func (t *shikimoriMangaTracker) AdaptSource(src Source) Source {
+	m, ok := src.(Manga)
+	if !ok {
+		return src
+	}
+	m.Private = false
+	m.Tags, m.ManagedTags, m.Priority, m.RereadValue = nil, nil, nil, nil
+	return m
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StateEntry is the snapshot of an entry at the moment it was last synced.
+type StateEntry struct {
+	IDAnilist       int       `json:"id_anilist"`
+	TargetID        TargetID  `json:"target_id"`
+	Source          string    `json:"source,omitempty"`
+	Target          string    `json:"target,omitempty"`
+	Title           string    `json:"title"`
+	Status          string    `json:"status"`
+	SourceUpdatedAt time.Time `json:"source_updated_at"`
+	TargetUpdatedAt time.Time `json:"target_updated_at"`
+	SyncedAt        time.Time `json:"synced_at"`
+}
+
+// SyncedBetween reports whether the entry was synced from the source to the target tracker,
+// entries saved by the previous versions were synced from AniList to MAL.
+func (e StateEntry) SyncedBetween(source, target string) bool {
+	if e.Source == "" && e.Target == "" {
+		return source == TrackerAnilist && target == TrackerMyAnimeList
+	}
+	return e.Source == source && e.Target == target
+}
+
+// State keeps the last synced snapshots grouped by updater prefix and source tracker, keyed by source ID.
+type State struct {
+	mu      sync.Mutex
+	Entries map[string]map[int]StateEntry `json:"entries"`
+}
+
+func NewState() *State {
+	return &State{Entries: make(map[string]map[int]StateEntry)}
+}
+
+func (s *State) Get(prefix string, id int) (StateEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.Entries[prefix][id]
+	return e, ok
+}
+
+func (s *State) Set(prefix string, id int, e StateEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Entries[prefix]; !ok {
+		s.Entries[prefix] = make(map[int]StateEntry)
+	}
+	s.Entries[prefix][id] = e
+}
+
+func (s *State) Print(w io.Writer) {
+	prefixes := make([]string, 0, len(s.Entries))
+	for prefix := range s.Entries {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		entries := s.Entries[prefix]
+
+		ids := make([]int, 0, len(entries))
+		for id := range entries {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+
+		fmt.Fprintf(w, "[%s] %d entries\n", prefix, len(entries))
+		for _, id := range ids {
+			e := entries[id]
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", id, e.TargetID, e.Status, e.SyncedAt.Format(time.DateTime), e.Title)
+		}
+	}
+}
+
+func readStateFile(path string) (*State, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return NewState(), nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	state := NewState()
+	if err := json.NewDecoder(file).Decode(state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func writeStateFile(path string, state *State) error {
+	if err := createDirIfNotExists(path); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(state)
+}
+
+func resetStateFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
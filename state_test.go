@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateNonAnilistSource(t *testing.T) {
+	synced := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	src := Anime{IDAnilist: -1, IDMal: 42, TitleEN: "Kitsu entry", Status: StatusWatching, UpdatedAt: synced}
+	state := NewState()
+
+	for _, source := range []string{TrackerKitsu, TrackerShikimori, TrackerMyAnimeList} {
+		u := &Updater{Prefix: "Anime", State: state, SourceTracker: source, TargetTracker: TrackerAnilist}
+		if source != TrackerMyAnimeList {
+			u.TargetTracker = TrackerMyAnimeList
+		}
+
+		u.saveState(src, 42, synced, synced)
+
+		if _, ok := u.unchangedSinceLastSync(src, nil); !ok {
+			t.Errorf("%s: unchanged source not skipped", source)
+		}
+
+		changed := src
+		changed.UpdatedAt = synced.Add(time.Hour)
+		if _, ok := u.unchangedSinceLastSync(changed, nil); ok {
+			t.Errorf("%s: changed source skipped", source)
+		}
+	}
+
+	// the AniList source with the same ID as the MAL ID of the other sources has no state
+	u := &Updater{Prefix: "Anime", State: state, SourceTracker: TrackerAnilist, TargetTracker: TrackerMyAnimeList}
+	anilist := Anime{IDAnilist: 42, IDMal: 42, UpdatedAt: synced}
+	if _, ok := u.stateEntry(anilist); ok {
+		t.Error("anilist source got the state of another source tracker")
+	}
+}
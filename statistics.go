@@ -1,14 +1,38 @@
 package main
 
-import "log"
+import (
+	"log"
+	"sync"
+)
 
+// Statistics counts the outcomes of a run, the counters are safe to increment from several workers.
 type Statistics struct {
-	UpdatedCount int
-	SkippedCount int
-	TotalCount   int
+	mu sync.Mutex
+
+	UpdatedCount  int
+	SkippedCount  int
+	IgnoredCount  int
+	TotalCount    int
+	ConflictCount int
+}
+
+func (s *Statistics) IncUpdated()  { s.inc(&s.UpdatedCount) }
+func (s *Statistics) IncSkipped()  { s.inc(&s.SkippedCount) }
+func (s *Statistics) IncIgnored()  { s.inc(&s.IgnoredCount) }
+func (s *Statistics) IncTotal()    { s.inc(&s.TotalCount) }
+func (s *Statistics) IncConflict() { s.inc(&s.ConflictCount) }
+
+func (s *Statistics) inc(counter *int) {
+	s.mu.Lock()
+	*counter++
+	s.mu.Unlock()
 }
 
-func (s Statistics) Print(prefix string) {
+func (s *Statistics) Print(prefix string) {
 	log.Printf("[%s] Updated %d out of %d\n", prefix, s.UpdatedCount, s.TotalCount)
 	log.Printf("[%s] Skipped %d\n", prefix, s.SkippedCount)
+	log.Printf("[%s] Ignored %d\n", prefix, s.IgnoredCount)
+	if s.ConflictCount > 0 {
+		log.Printf("[%s] Conflicts %d\n", prefix, s.ConflictCount)
+	}
 }
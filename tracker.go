@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// Tracker names used for the source and target in the config.
+const (
+	TrackerAnilist     = "anilist"
+	TrackerMyAnimeList = "myanimelist"
+	TrackerKitsu       = "kitsu"
+	TrackerShikimori   = "shikimori"
+)
+
+// Entry is a list entry of a tracker, it is both a source and a target.
+type Entry interface {
+	Source
+	Target
+}
+
+// Tracker is a site with the user's list of one media type.
+// Entries are identified by their MAL IDs on every tracker, so any tracker can be the source or the target.
+type Tracker interface {
+	Name() string
+	GetList(ctx context.Context) ([]Entry, error)
+	GetByID(ctx context.Context, id TargetID) (Target, error)
+	Search(ctx context.Context, name string) ([]Target, error)
+	Update(ctx context.Context, id TargetID, src Source) error
+}
+
+// sourceAdapter is implemented by trackers that keep fewer fields than the sources have,
+// the fields are dropped from the source so they don't count as a difference.
+type sourceAdapter interface {
+	AdaptSource(Source) Source
+}
+
+// newUpdater creates an updater that writes to the tracker.
+func newUpdater(prefix string, t Tracker, mappings *Mappings, ignores *IgnoreRules) *Updater {
+	u := &Updater{
+		Prefix:     prefix,
+		Statistics: new(Statistics),
+		Mappings:   mappings,
+		Ignores:    ignores,
+
+		GetTargetByIDFunc:        t.GetByID,
+		GetTargetsByNameFunc:     t.Search,
+		UpdateTargetBySourceFunc: t.Update,
+	}
+	if a, ok := t.(sourceAdapter); ok {
+		u.AdaptSourceFunc = a.AdaptSource
+	}
+	return u
+}
+
+func validTracker(name string) bool {
+	switch name {
+	case TrackerAnilist, TrackerMyAnimeList, TrackerKitsu, TrackerShikimori:
+		return true
+	default:
+		return false
+	}
+}
+
+// trackerClients holds the clients of the trackers used by the run.
+type trackerClients struct {
+	anilist   *AnilistClient
+	scores    ScoreConverter
+	mal       *MyAnimeListClient
+	kitsu     *KitsuClient
+	shikimori *ShikimoriClient
+
+	oauths []*OAuth
+}
+
+// newTrackerClients authorizes and creates the clients of the named trackers.
+func newTrackerClients(ctx context.Context, config Config, names ...string) (*trackerClients, error) {
+	c := &trackerClients{}
+	for _, name := range names {
+		if err := c.add(ctx, config, name); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *trackerClients) add(ctx context.Context, config Config, name string) error {
+	switch name {
+	case TrackerAnilist:
+		if c.anilist != nil {
+			return nil
+		}
+
+		oauth, err := NewAnilistOAuth(ctx, config)
+		if err != nil {
+			return fmt.Errorf("error creating anilist oauth: %w", err)
+		}
+
+		client, err := NewAnilistClient(ctx, oauth, config.Anilist, config.HTTP)
+		if err != nil {
+			return fmt.Errorf("error creating anilist client: %w", err)
+		}
+
+		scoreFormat, err := client.GetScoreFormat(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting anilist score format: %w", err)
+		}
+
+		scores, err := NewScoreConverter(scoreFormat, config.ScoreRounding)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Anilist score format: %s", scoreFormat)
+
+		c.anilist, c.scores = client, scores
+		c.oauths = append(c.oauths, oauth)
+	case TrackerMyAnimeList:
+		if c.mal != nil {
+			return nil
+		}
+
+		oauth, err := NewMyAnimeListOAuth(ctx, config)
+		if err != nil {
+			return fmt.Errorf("error creating mal oauth: %w", err)
+		}
+
+		client, err := NewMyAnimeListClient(ctx, oauth, config.MyAnimeList, config.HTTP)
+		if err != nil {
+			return fmt.Errorf("error creating mal client: %w", err)
+		}
+
+		c.mal = client
+		c.oauths = append(c.oauths, oauth)
+	case TrackerKitsu:
+		if c.kitsu != nil {
+			return nil
+		}
+
+		oauth, err := NewKitsuOAuth(ctx, config)
+		if err != nil {
+			return fmt.Errorf("error creating kitsu oauth: %w", err)
+		}
+
+		client, err := NewKitsuClient(ctx, oauth, config.Kitsu, config.HTTP)
+		if err != nil {
+			return fmt.Errorf("error creating kitsu client: %w", err)
+		}
+
+		c.kitsu = client
+		c.oauths = append(c.oauths, oauth)
+	case TrackerShikimori:
+		if c.shikimori != nil {
+			return nil
+		}
+
+		oauth, err := NewShikimoriOAuth(ctx, config)
+		if err != nil {
+			return fmt.Errorf("error creating shikimori oauth: %w", err)
+		}
+
+		client, err := NewShikimoriClient(ctx, oauth, config.Shikimori, config.HTTP)
+		if err != nil {
+			return fmt.Errorf("error creating shikimori client: %w", err)
+		}
+
+		c.shikimori = client
+		c.oauths = append(c.oauths, oauth)
+	default:
+		return fmt.Errorf("unknown tracker: %s", name)
+	}
+
+	log.Printf("%s client created", name)
+	return nil
+}
+
+// trackers returns the anime and manga trackers of the named site.
+func (c *trackerClients) trackers(config Config, name string) (anime, manga Tracker) {
+	switch name {
+	case TrackerAnilist:
+		return &anilistAnimeTracker{c: c.anilist, scores: c.scores, lists: config.CustomLists.Anime, extra: config.ExtraFields.Anime},
+			&anilistMangaTracker{c: c.anilist, scores: c.scores, lists: config.CustomLists.Manga, extra: config.ExtraFields.Manga}
+	case TrackerKitsu:
+		return &kitsuAnimeTracker{c: c.kitsu}, &kitsuMangaTracker{c: c.kitsu}
+	case TrackerShikimori:
+		return &shikimoriAnimeTracker{c: c.shikimori}, &shikimoriMangaTracker{c: c.shikimori}
+	default:
+		return &malAnimeTracker{c: c.mal}, &malMangaTracker{c: c.mal}
+	}
+}
+
+func newSourcesFromEntries(entries []Entry) []Source {
+	res := make([]Source, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, e)
+	}
+	return res
+}
+
+func newTargetsFromEntries(entries []Entry) []Target {
+	res := make([]Target, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, e)
+	}
+	return res
+}
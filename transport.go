@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	anilistRequestsPerMinute = 90
+	malRequestsPerMinute     = 60
+
+	rateLimitMaxRetries = 5
+	rateLimitBaseDelay  = time.Second
+	rateLimitMaxDelay   = 2 * time.Minute
+	// shorter waits are logged only in verbose mode, so the regular pacing is quiet
+	rateLimitLogThreshold = 5 * time.Second
+)
+
+// RateLimitTransport paces requests with a token bucket and retries rate limited
+// and unavailable responses, honoring Retry-After and X-RateLimit-* headers.
+type RateLimitTransport struct {
+	Name string
+	Base http.RoundTripper
+
+	// Limit requests are allowed per Period, Burst of them at once.
+	Limit  int
+	Period time.Duration
+	Burst  int
+
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+
+	// Verbose logs the short waits too.
+	Verbose bool
+
+	mu           sync.Mutex
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+func NewRateLimitTransport(name string, base http.RoundTripper, requestsPerMinute int) *RateLimitTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &RateLimitTransport{
+		Name:       name,
+		Base:       base,
+		Limit:      requestsPerMinute,
+		Period:     time.Minute,
+		Burst:      1,
+		MaxRetries: rateLimitMaxRetries,
+		BaseDelay:  rateLimitBaseDelay,
+		MaxDelay:   rateLimitMaxDelay,
+	}
+}
+
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		if err := t.sleep(ctx, t.reserve(), "rate limit pacing"); err != nil {
+			return nil, err
+		}
+
+		r, err := t.rewind(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.Base.RoundTrip(r)
+		retryable := attempt < t.MaxRetries && (req.Body == nil || req.GetBody != nil)
+
+		if err != nil {
+			if !retryable || ctx.Err() != nil {
+				return nil, err
+			}
+			if err := t.sleep(ctx, t.backoff(attempt), "request error: "+err.Error()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		t.observe(resp)
+
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			return resp, nil
+		}
+		if !retryable {
+			return resp, nil
+		}
+
+		wait, ok := retryAfter(resp.Header, time.Now())
+		if !ok {
+			wait = t.backoff(attempt)
+		}
+
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		t.block(wait)
+		if err := t.sleep(ctx, wait, resp.Status); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// rewind returns the request to send, retries get a fresh copy of the body.
+func (t *RateLimitTransport) rewind(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 0 || req.Body == nil || req.GetBody == nil {
+		return req, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	r := req.Clone(req.Context())
+	r.Body = body
+	return r, nil
+}
+
+// reserve takes a token from the bucket and returns how long to wait for it.
+func (t *RateLimitTransport) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+
+	var wait time.Duration
+	if t.Limit > 0 && t.Period > 0 {
+		rate := float64(t.Limit) / float64(t.Period)
+		burst := float64(max(t.Burst, 1))
+
+		if t.last.IsZero() {
+			t.tokens = burst
+		} else {
+			t.tokens = min(burst, t.tokens+float64(now.Sub(t.last))*rate)
+		}
+		t.last = now
+
+		t.tokens--
+		if t.tokens < 0 {
+			wait = time.Duration(-t.tokens / rate)
+		}
+	}
+
+	if blocked := t.blockedUntil.Sub(now); blocked > wait {
+		wait = blocked
+	}
+
+	return wait
+}
+
+// observe blocks the next requests when the server reports the limit is exhausted.
+func (t *RateLimitTransport) observe(resp *http.Response) {
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return
+	}
+
+	wait, ok := retryAfter(resp.Header, time.Now())
+	if !ok {
+		wait = t.Period / time.Duration(max(t.Limit, 1))
+	}
+
+	t.block(wait)
+}
+
+func (t *RateLimitTransport) block(wait time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if until := time.Now().Add(wait); until.After(t.blockedUntil) {
+		t.blockedUntil = until
+	}
+}
+
+// backoff returns the exponential delay for the attempt with up to 50% of jitter.
+func (t *RateLimitTransport) backoff(attempt int) time.Duration {
+	delay := t.BaseDelay << attempt
+	if delay <= 0 || delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (t *RateLimitTransport) sleep(ctx context.Context, d time.Duration, reason string) error {
+	if d <= 0 {
+		return nil
+	}
+
+	if d >= rateLimitLogThreshold {
+		log.Printf("[%s] Waiting %s: %s", t.Name, d.Round(time.Second), reason)
+	} else if t.Verbose {
+		log.Printf("[%s] Waiting %s: %s", t.Name, d, reason)
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryAfter reads the delay from Retry-After (seconds or HTTP date) or X-RateLimit-Reset (unix time).
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+		if at, err := http.ParseTime(v); err == nil {
+			return max(at.Sub(now), 0), true
+		}
+	}
+
+	if v := h.Get("X-RateLimit-Reset"); v != "" {
+		if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return max(time.Unix(unix, 0).Sub(now), 0), true
+		}
+	}
+
+	return 0, false
+}
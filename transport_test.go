@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestRateLimitTransport() *RateLimitTransport {
+	return &RateLimitTransport{
+		Name:       "test",
+		Base:       http.DefaultTransport,
+		MaxRetries: 2,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   10 * time.Millisecond,
+	}
+}
+
+func TestRateLimitTransportRetryAfter(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(status)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		client := &http.Client{Transport: newTestRateLimitTransport()}
+
+		start := time.Now()
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		srv.Close()
+
+		if resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+			t.Errorf("%d: got status %d after %d calls, want 200 after 2", status, resp.StatusCode, calls.Load())
+		}
+		if elapsed := time.Since(start); elapsed < time.Second {
+			t.Errorf("%d: retried after %s, want Retry-After of 1s", status, elapsed)
+		}
+	}
+}
+
+func TestRateLimitTransportRemainingZero(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("Retry-After", "1")
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: newTestRateLimitTransport()}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	start := time.Now()
+	resp, err = client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("next request sent after %s, want it blocked for about 1s", elapsed)
+	}
+}
+
+func TestRateLimitTransportMaxRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	tr := newTestRateLimitTransport()
+	client := &http.Client{Transport: tr}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want the last 503", resp.StatusCode)
+	}
+	if want := int32(tr.MaxRetries + 1); calls.Load() != want {
+		t.Errorf("got %d calls, want %d", calls.Load(), want)
+	}
+}
+
+func TestRateLimitTransportRewindsBody(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: newTestRateLimitTransport()}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{"query":"test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.GetBody == nil {
+		t.Fatal("request without GetBody")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(bodies) != 2 || bodies[0] != bodies[1] || bodies[1] != `{"query":"test"}` {
+		t.Errorf("got bodies %q, want the same body twice", bodies)
+	}
+}
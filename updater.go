@@ -2,36 +2,92 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+var errTargetNotFound = errors.New("no target found")
+
 type TargetID int
 
 type Source interface {
+	GetAnilistID() int
 	GetStatusString() string
 	GetTargetID() TargetID
 	GetTitle() string
+	GetTitles() []string
+	GetUpdatedAt() time.Time
 	GetStringDiffWithTarget(Target) string
-	SameProgressWithTarget(Target) bool
+	SameProgressWithTarget(tgt Target, debugf func(format string, v ...any)) bool
 	SameTypeWithTarget(Target) bool
 	String() string
 }
 
 type Target interface {
 	GetTargetID() TargetID
+	GetUpdatedAt() time.Time
 	String() string
 }
 
+// targetMerger is a source that keeps values of its target it doesn't write itself, like the MAL tags.
+type targetMerger interface {
+	MergeTarget(Target) Source
+	// NeedsTarget reports whether the source can't be written without its target.
+	NeedsTarget() bool
+}
+
 type Updater struct {
-	Prefix       string
-	Statistics   *Statistics
-	IgnoreTitles map[string]struct{}
+	Prefix     string
+	Statistics *Statistics
+	Mappings   *Mappings
+	Ignores    *IgnoreRules
+
+	// Reverse enables bidirectional sync: when a target was modified after its source,
+	// the target is pushed back to the source side through the reverse updater.
+	Reverse *Updater
+	// Report collects the outcome of every processed entry, nil if not requested.
+	Report *Report
+	// State holds the snapshots of the previous runs. Sources unchanged since then are skipped,
+	// and in bidirectional mode it is the baseline to detect conflicts.
+	State *State
+	// SourceTracker and TargetTracker are the trackers synced, state entries saved for other ones are ignored.
+	SourceTracker string
+	TargetTracker string
+	// Prompter asks the user to confirm matches found by name, nil if not interactive.
+	Prompter *Prompter
+	// Force updates targets even when the progress is the same, DryRun only logs the updates.
+	Force  bool
+	DryRun bool
+	// Verbose logs the details of every entry.
+	Verbose bool
+
+	// Policy tells which fields are written to the target.
+	Policy SyncPolicy
+	// Workers is the number of sources processed concurrently, the request rate is limited by the clients.
+	Workers int
+	// Orphans collects the targets without source, nil if not requested.
+	Orphans *Orphans
+
+	// failures counts the sources whose target lookup failed, their targets look like orphans then
+	failures atomic.Int32
 
 	GetTargetByIDFunc        func(context.Context, TargetID) (Target, error)
 	GetTargetsByNameFunc     func(context.Context, string) ([]Target, error)
 	UpdateTargetBySourceFunc func(context.Context, TargetID, Source) error
+	// AdaptSourceFunc drops the source fields the target doesn't keep, nil if it keeps all of them.
+	AdaptSourceFunc func(Source) Source
+}
+
+// Reset starts new statistics and report for the next run.
+func (u *Updater) Reset(report *Report) {
+	u.Statistics = new(Statistics)
+	u.Report = report
+	u.failures.Store(0)
 }
 
 func (u *Updater) Update(ctx context.Context, srcs []Source, tgts []Target) {
@@ -40,114 +96,505 @@ func (u *Updater) Update(ctx context.Context, srcs []Source, tgts []Target) {
 		tgtsByID[tgt.GetTargetID()] = tgt
 	}
 
-	var statusStr string
+	matched := make(map[TargetID]struct{}, len(srcs))
+	var mu sync.Mutex
+
+	// sources come grouped by status, each group is finished before the next one starts
+	// so the log stays grouped even with several workers
+	for _, group := range groupByStatus(srcs) {
+		log.Printf("[%s] Processing for status: %s", u.Prefix, group[0].GetStatusString())
+
+		u.forEach(ctx, group, func(src Source) {
+			if tgtID := u.process(ctx, src, tgtsByID); tgtID > 0 {
+				mu.Lock()
+				matched[tgtID] = struct{}{}
+				mu.Unlock()
+			}
+		})
+	}
+
+	// targets of every source count as matched, the excluded and failed ones too
+	u.matchSourceTargets(srcs, tgts, matched)
+
+	if u.Orphans != nil {
+		for _, tgt := range unmatchedTargets(tgts, matched) {
+			u.Orphans.Add(u.Prefix, tgt)
+		}
+		if n := u.failures.Load(); n > 0 {
+			log.Printf("[%s] %d entries failed to match, their targets may be listed as orphans", u.Prefix, n)
+		}
+	}
+
+	if u.Reverse == nil {
+		return
+	}
+
+	// targets without any source exist only on the target side, so the source side is stale
+	var unmatched []Source
+	for _, tgt := range unmatchedTargets(tgts, matched) {
+		s, ok := tgt.(Source)
+		if !ok {
+			continue
+		}
+		if u.Reverse.excluded(s) || u.Mappings.Pinned(tgt.GetTargetID()) {
+			u.debugf("[%s] Not pushing back %s excluded from the sync", u.Prefix, s.GetTitle())
+			continue
+		}
+		unmatched = append(unmatched, s)
+	}
+
+	if len(unmatched) > 0 {
+		log.Printf("[%s] Pushing %d target-only entries back to source", u.Prefix, len(unmatched))
+		u.Reverse.Statistics, u.Reverse.Report = u.Statistics, u.Report
+		u.Reverse.Update(ctx, unmatched, nil)
+	}
+}
+
+// matchSourceTargets marks the targets of the sources as matched even if they were ignored or failed to sync.
+// Sources excluded from the sync without target ID are matched by title, so their targets are never
+// deleted or pushed back.
+func (u *Updater) matchSourceTargets(srcs []Source, tgts []Target, matched map[TargetID]struct{}) {
 	for _, src := range srcs {
-		if src.GetStatusString() == "" {
+		if id := u.targetID(src); id > 0 {
+			matched[id] = struct{}{}
+			continue
+		}
+		if !u.excluded(src) {
 			continue
 		}
+		for _, tgt := range tgts {
+			if sameTitle(src, tgt) {
+				u.debugf("[%s] Excluded %s matched by title: %s", u.Prefix, src.GetTitle(), tgt.String())
+				matched[tgt.GetTargetID()] = struct{}{}
+			}
+		}
+	}
+}
+
+// sameTitle reports whether the source and the target share a title, ignoring case.
+func sameTitle(src Source, tgt Target) bool {
+	t, ok := tgt.(Source)
+	if !ok {
+		return false
+	}
+	for _, a := range src.GetTitles() {
+		for _, b := range t.GetTitles() {
+			if a != "" && strings.EqualFold(a, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// excluded reports whether the user excluded the source from the sync by an ignore rule or a skip mapping.
+func (u *Updater) excluded(src Source) bool {
+	if _, ok := u.Ignores.Match(src); ok {
+		return true
+	}
+	m, ok := u.Mappings.Find(src)
+	return ok && m.Skip
+}
+
+// unmatchedTargets returns the targets not matched by any source.
+func unmatchedTargets(tgts []Target, matched map[TargetID]struct{}) []Target {
+	var unmatched []Target
+	for _, tgt := range tgts {
+		if _, ok := matched[tgt.GetTargetID()]; !ok {
+			unmatched = append(unmatched, tgt)
+		}
+	}
+	return unmatched
+}
+
+// process syncs a single source and returns the ID of the matched target or 0 if not matched.
+func (u *Updater) process(ctx context.Context, src Source, tgtsByID map[TargetID]Target) TargetID {
+	u.Statistics.IncTotal()
 
-		u.Statistics.TotalCount++
+	u.debugf("[%s] Processing for: %s", u.Prefix, src.String())
+
+	if rule, ok := u.Ignores.Match(src); ok {
+		log.Printf("[%s] Ignoring %s by %s", u.Prefix, src.GetTitle(), rule)
+		u.Statistics.IncIgnored()
+		u.report(src, nil, ReportActionIgnored, "", nil)
+		return 0
+	}
+
+	if m, ok := u.Mappings.Find(src); ok && m.Skip {
+		log.Printf("[%s] Ignoring %s: %s", u.Prefix, strings.ToLower(u.Prefix), src.GetTitle())
+		u.Statistics.IncIgnored()
+		u.report(src, nil, ReportActionIgnored, "", nil)
+		return 0
+	}
+
+	if entry, ok := u.unchangedSinceLastSync(src, tgtsByID); ok {
+		u.debugf("[%s] Unchanged since last sync: %s", u.Prefix, src.GetTitle())
+		u.Statistics.IncSkipped()
+		u.report(src, tgtsByID[entry.TargetID], ReportActionSkipped, "", nil)
+		return entry.TargetID
+	}
+
+	return u.updateSourceByTargets(ctx, src, tgtsByID)
+}
+
+// forEach calls fn for every source using up to Workers goroutines.
+// Interactive prompts need the terminal for themselves, so the prompter forces a single worker.
+func (u *Updater) forEach(ctx context.Context, srcs []Source, fn func(Source)) {
+	workers := u.Workers
+	if workers < 1 || u.Prompter != nil {
+		workers = 1
+	}
 
-		if statusStr != src.GetStatusString() {
-			statusStr = src.GetStatusString()
-			log.Printf("[%s] Processing for status: %s", u.Prefix, statusStr)
+	ch := make(chan Source)
+	var wg sync.WaitGroup
+	for i := 0; i < min(workers, len(srcs)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for src := range ch {
+				fn(src)
+			}
+		}()
+	}
+
+	for _, src := range srcs {
+		if ctx.Err() != nil {
+			break
 		}
+		ch <- src
+	}
+	close(ch)
 
-		DPrintf("[%s] Processing for: %s", u.Prefix, src.String())
+	wg.Wait()
+}
 
-		if _, ok := u.IgnoreTitles[strings.ToLower(src.GetTitle())]; ok {
-			log.Printf("[%s] Ignoring anime: %s", u.Prefix, src.GetTitle())
-			u.Statistics.SkippedCount++
+// groupByStatus splits consecutive sources with the same status, sources without status are dropped.
+func groupByStatus(srcs []Source) [][]Source {
+	var groups [][]Source
+	for _, src := range srcs {
+		status := src.GetStatusString()
+		if status == "" {
 			continue
 		}
-
-		u.updateSourceByTargets(ctx, src, tgtsByID)
+		if n := len(groups); n > 0 && groups[n-1][0].GetStatusString() == status {
+			groups[n-1] = append(groups[n-1], src)
+			continue
+		}
+		groups = append(groups, []Source{src})
 	}
+	return groups
 }
 
-func (u *Updater) updateSourceByTargets(ctx context.Context, src Source, tgts map[TargetID]Target) {
-	tgtID := src.GetTargetID()
+// updateSourceByTargets returns the ID of the target matched with the source or 0 if not matched.
+func (u *Updater) updateSourceByTargets(ctx context.Context, src Source, tgts map[TargetID]Target) TargetID {
+	tgtID := u.targetID(src)
+	tgtUpdatedAt := time.Time{}
+	diff := ""
 
-	if !(*forceSync) { // filter sources by different progress with targets
-		tgt, ok := tgts[src.GetTargetID()]
+	// filter sources by different progress with targets, the policy needs the target values too
+	if !u.Force || u.Reverse != nil || u.Orphans != nil || u.Policy.NeedsTarget() || needsTarget(src) {
+		tgt, ok := tgts[tgtID]
 		if !ok {
 			var err error
 			tgt, err = u.findTarget(ctx, src)
 			if err != nil {
-				log.Printf("[%s] Error processing target anime: %v", u.Prefix, err)
-				u.Statistics.SkippedCount++
-				return
+				if errors.Is(err, errSkippedByUser) {
+					u.Statistics.IncIgnored()
+					u.report(src, nil, ReportActionIgnored, "", nil)
+					return 0
+				}
+				log.Printf("[%s] Error processing %s target %s: %v", u.Prefix, u.TargetTracker, strings.ToLower(u.Prefix), err)
+				u.Statistics.IncSkipped()
+				if errors.Is(err, errTargetNotFound) {
+					u.report(src, nil, ReportActionNotFound, "", err)
+				} else {
+					u.failures.Add(1)
+					u.report(src, nil, ReportActionError, "", err)
+				}
+				return 0
 			}
 		}
 
-		DPrintf("[%s] Target: %s", u.Prefix, tgt.String())
+		u.debugf("[%s] Target: %s", u.Prefix, tgt.String())
+
+		tgtID = tgt.GetTargetID()
+		tgtUpdatedAt = tgt.GetUpdatedAt()
 
-		if src.SameProgressWithTarget(tgt) {
-			u.Statistics.SkippedCount++
-			return
+		orig := src
+		src = u.applyPolicy(mergeTarget(u.adaptSource(src), tgt), tgt)
+
+		if !u.Force && src.SameProgressWithTarget(tgt, u.debugf) {
+			u.Statistics.IncSkipped()
+			u.report(src, tgt, ReportActionSkipped, "", nil)
+			u.saveState(src, tgtID, src.GetUpdatedAt(), tgtUpdatedAt)
+			return tgtID
 		}
 
-		log.Printf("[%s] Title: %s", u.Prefix, src.GetTitle())
-		log.Printf("[%s] Progress is not same, need to update: %s", u.Prefix, src.GetStringDiffWithTarget(tgt))
+		if u.Reverse != nil && u.isConflict(src, tgt) {
+			log.Printf("[%s] Conflict: %s changed on both sides since last sync, keeping the newest", u.Prefix, src.GetTitle())
+			u.Statistics.IncConflict()
+		}
 
-		tgtID = tgt.GetTargetID()
+		if u.Reverse != nil && tgt.GetUpdatedAt().After(src.GetUpdatedAt()) {
+			if u.updateSourceByTarget(ctx, orig, tgt) {
+				u.saveState(src, tgtID, time.Now().UTC(), tgtUpdatedAt)
+			}
+			return tgtID
+		}
+
+		diff = src.GetStringDiffWithTarget(tgt)
+
+		log.Printf("[%s] Progress is not same, need to update: %s: %s", u.Prefix, src.GetTitle(), diff)
+	}
+
+	if u.DryRun { // skip update if dry run
+		log.Printf("[%s] Dry run: Skipping update for %s %s", u.Prefix, strings.ToLower(u.Prefix), src.GetTitle())
+		u.reportByID(src, tgtID, ReportActionDryRun, diff, nil)
+		return tgtID
+	}
+
+	if err := u.updateTarget(ctx, tgtID, src); err != nil {
+		u.reportByID(src, tgtID, ReportActionError, diff, err)
+		return tgtID
+	}
+
+	u.reportByID(src, tgtID, ReportActionUpdated, diff, nil)
+	u.saveState(src, tgtID, src.GetUpdatedAt(), time.Now().UTC())
+
+	return tgtID
+}
+
+// updateSourceByTarget pushes a target that is newer than its source back to the source side.
+func (u *Updater) updateSourceByTarget(ctx context.Context, src Source, tgt Target) bool {
+	tgtSrc, ok := tgt.(Source)
+	if !ok {
+		log.Printf("[%s] Target can't be used as source: %s", u.Prefix, tgt.String())
+		u.Statistics.IncSkipped()
+		u.report(src, tgt, ReportActionSkipped, "", nil)
+		return false
+	}
+
+	tgtSrc = u.Reverse.applyPolicy(u.Reverse.adaptSource(tgtSrc), src)
+	diff := tgtSrc.GetStringDiffWithTarget(src)
+
+	log.Printf("[%s] Target is newer, need to update source: %s: %s", u.Prefix, src.GetTitle(), diff)
+
+	if u.DryRun {
+		log.Printf("[%s] Dry run: Skipping source update for %s", u.Prefix, src.GetTitle())
+		u.report(src, tgt, ReportActionDryRun, diff, nil)
+		return false
+	}
+
+	if err := u.Reverse.UpdateTargetBySourceFunc(ctx, tgt.GetTargetID(), tgtSrc); err != nil {
+		log.Printf("[%s] Error updating source: %s: %v", u.Prefix, src.GetTitle(), err)
+		u.report(src, tgt, ReportActionError, diff, err)
+		return false
+	}
+
+	log.Printf("[%s] Updated source %s", u.Prefix, src.GetTitle())
+
+	u.Statistics.IncUpdated()
+	u.report(src, tgt, ReportActionUpdated, diff, nil)
+
+	return true
+}
+
+// unchangedSinceLastSync reports whether the source, and in bidirectional mode its target too,
+// were not modified since the last synced snapshot.
+func (u *Updater) unchangedSinceLastSync(src Source, tgts map[TargetID]Target) (StateEntry, bool) {
+	if u.Force || u.State == nil {
+		return StateEntry{}, false
+	}
+
+	entry, ok := u.stateEntry(src)
+	if !ok || src.GetUpdatedAt().IsZero() || src.GetUpdatedAt().After(entry.SourceUpdatedAt) {
+		return StateEntry{}, false
+	}
+
+	if id := u.targetID(src); id > 0 && id != entry.TargetID { // mapping changed
+		return StateEntry{}, false
+	}
+
+	if u.Reverse != nil {
+		tgt, ok := tgts[entry.TargetID]
+		if !ok || tgt.GetUpdatedAt().After(entry.TargetUpdatedAt) {
+			return StateEntry{}, false
+		}
+	}
+
+	return entry, true
+}
+
+func (u *Updater) isConflict(src Source, tgt Target) bool {
+	if u.State == nil {
+		return false
+	}
+
+	entry, ok := u.stateEntry(src)
+	if !ok {
+		return false
+	}
+
+	return src.GetUpdatedAt().After(entry.SourceUpdatedAt) && tgt.GetUpdatedAt().After(entry.TargetUpdatedAt)
+}
+
+// stateEntry returns the last synced snapshot of the source, if it was synced between the same trackers.
+func (u *Updater) stateEntry(src Source) (StateEntry, bool) {
+	group, id := u.stateKey(src)
+	if id <= 0 {
+		return StateEntry{}, false
 	}
 
-	if *dryRun { // skip update if dry run
-		log.Printf("[%s] Dry run: Skipping update for anime %s", u.Prefix, src.GetTitle())
+	entry, ok := u.State.Get(group, id)
+	if !ok || !entry.SyncedBetween(u.SourceTracker, u.TargetTracker) {
+		return StateEntry{}, false
+	}
+	return entry, true
+}
+
+// stateKey returns the group and ID the snapshot of the source is kept under. AniList sources are keyed
+// by their AniList ID in the group of the prefix, the sources of other trackers by the MAL ID every tracker has,
+// in a group of their own.
+func (u *Updater) stateKey(src Source) (string, int) {
+	if u.SourceTracker == "" || u.SourceTracker == TrackerAnilist {
+		return u.Prefix, src.GetAnilistID()
+	}
+	return u.Prefix + "/" + u.SourceTracker, int(src.GetTargetID())
+}
+
+func (u *Updater) saveState(src Source, tgtID TargetID, srcUpdatedAt, tgtUpdatedAt time.Time) {
+	if u.State == nil || u.DryRun {
 		return
 	}
 
-	u.updateTarget(ctx, tgtID, src)
+	group, id := u.stateKey(src)
+	if id <= 0 {
+		return
+	}
+
+	u.State.Set(group, id, StateEntry{
+		IDAnilist:       src.GetAnilistID(),
+		TargetID:        tgtID,
+		Source:          u.SourceTracker,
+		Target:          u.TargetTracker,
+		Title:           src.GetTitle(),
+		Status:          src.GetStatusString(),
+		SourceUpdatedAt: srcUpdatedAt,
+		TargetUpdatedAt: tgtUpdatedAt,
+		SyncedAt:        time.Now().UTC(),
+	})
+}
+
+func (u *Updater) adaptSource(src Source) Source {
+	if u.AdaptSourceFunc == nil {
+		return src
+	}
+	return u.AdaptSourceFunc(src)
+}
+
+func mergeTarget(src Source, tgt Target) Source {
+	if m, ok := src.(targetMerger); ok {
+		return m.MergeTarget(tgt)
+	}
+	return src
+}
+
+func needsTarget(src Source) bool {
+	m, ok := src.(targetMerger)
+	return ok && m.NeedsTarget()
+}
+
+// targetID returns the target ID pinned by the mappings or the source's own one.
+func (u *Updater) targetID(src Source) TargetID {
+	if m, ok := u.Mappings.Find(src); ok && m.MalID > 0 {
+		return TargetID(m.MalID)
+	}
+	return src.GetTargetID()
 }
 
 func (u *Updater) findTarget(ctx context.Context, src Source) (Target, error) {
-	tgtID := src.GetTargetID()
+	tgtID := u.targetID(src)
 
 	if tgtID > 0 {
-		DPrintf("[%s] Finding target by id: %d", u.Prefix, tgtID)
+		u.debugf("[%s] Finding target by id: %d", u.Prefix, tgtID)
 
 		tgt, err := u.GetTargetByIDFunc(ctx, tgtID)
 		if err != nil {
-			return nil, fmt.Errorf("error getting mal anime by id: %s: %w", src.GetTitle(), err)
+			return nil, fmt.Errorf("error getting %s %s by id: %s: %w", u.TargetTracker, strings.ToLower(u.Prefix), src.GetTitle(), err)
 		}
 		return tgt, nil
 	}
 
-	DPrintf("[%s] Finding target by name: %s", u.Prefix, src.GetTitle())
+	u.debugf("[%s] Finding target by name: %s", u.Prefix, src.GetTitle())
 
 	tgts, err := u.GetTargetsByNameFunc(ctx, src.GetTitle())
 	if err != nil {
 		return nil, fmt.Errorf("error getting targets by source name: %s: %w", src.GetTitle(), err)
 	}
 
+	if u.Prompter != nil && len(tgts) > 0 {
+		return u.chooseTarget(ctx, src, tgts)
+	}
+
 	for _, tgt := range tgts {
 		if src.SameTypeWithTarget(tgt) {
-			DPrintf("[%s] Found target by name: %s", u.Prefix, src.GetTitle())
+			u.debugf("[%s] Found target by name: %s", u.Prefix, src.GetTitle())
 			return tgt, nil
 		} else {
-			DPrintf("[%s] Ignoring target by name: %s", u.Prefix, tgt.String())
+			u.debugf("[%s] Ignoring target by name: %s", u.Prefix, tgt.String())
 		}
 	}
 
-	return nil, fmt.Errorf("no target found for source: %s", src.GetTitle())
+	return nil, fmt.Errorf("%w for source: %s", errTargetNotFound, src.GetTitle())
 }
 
-func (u *Updater) updateTarget(ctx context.Context, id TargetID, src Source) {
-	DPrintf("[%s] Updating %s", u.Prefix, src.GetTitle())
+func (u *Updater) updateTarget(ctx context.Context, id TargetID, src Source) error {
+	u.debugf("[%s] Updating %s", u.Prefix, src.GetTitle())
 
 	if err := u.UpdateTargetBySourceFunc(ctx, id, src); err != nil {
 		log.Printf("[%s] Error updating target: %s: %v", u.Prefix, src.GetTitle(), err)
-		return
+		return err
 	}
 
 	log.Printf("[%s] Updated %s", u.Prefix, src.GetTitle())
 
-	u.Statistics.UpdatedCount++
+	u.Statistics.IncUpdated()
+
+	return nil
+}
+
+// report adds the outcome to the report, tgt is nil when the target is unknown.
+func (u *Updater) report(src Source, tgt Target, action ReportAction, diff string, err error) {
+	tgtID := src.GetTargetID()
+	if tgt != nil {
+		tgtID = tgt.GetTargetID()
+	}
+	u.reportByID(src, tgtID, action, diff, err)
+}
+
+func (u *Updater) reportByID(src Source, tgtID TargetID, action ReportAction, diff string, err error) {
+	if u.Report == nil {
+		return
+	}
+
+	e := ReportEntry{
+		Type:      u.Prefix,
+		AnilistID: max(src.GetAnilistID(), 0),
+		MalID:     max(int(tgtID), 0),
+		Title:     src.GetTitle(),
+		Action:    action,
+		Diff:      diff,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	u.Report.Add(e)
 }
 
-func DPrintf(format string, v ...any) {
-	if !(*verbose) {
+// debugf logs the message in verbose mode.
+func (u *Updater) debugf(format string, v ...any) {
+	if !u.Verbose {
 		return
 	}
 	log.Printf(format, v...)
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const watchRetryDelay = time.Minute
+
+// watch runs the sync every interval until the context is canceled.
+// After a failed cycle it retries with an exponential backoff capped by the interval.
+func (a *App) watch(ctx context.Context, interval time.Duration) error {
+	log.Printf("Watch mode: syncing every %s", interval)
+
+	var failures int
+	for cycle := 1; ; cycle++ {
+		start := time.Now()
+
+		err := a.runCycle(ctx, cycle)
+		if ctx.Err() != nil {
+			log.Println("Watch mode stopped")
+			return nil
+		}
+
+		wait := interval
+		if err != nil {
+			failures++
+			wait = backoffDelay(failures, interval)
+			log.Printf("Cycle %d failed after %s: %v", cycle, time.Since(start).Round(time.Second), err)
+		} else {
+			failures = 0
+			log.Printf("Cycle %d finished in %s", cycle, time.Since(start).Round(time.Second))
+		}
+
+		log.Printf("Next sync at %s", time.Now().Add(wait).Format(time.DateTime))
+
+		select {
+		case <-ctx.Done():
+			log.Println("Watch mode stopped")
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (a *App) runCycle(ctx context.Context, cycle int) error {
+	if cycle > 1 {
+		if err := a.refreshTokens(); err != nil {
+			return err
+		}
+	}
+
+	if err := a.runOnce(ctx); err != nil {
+		return err
+	}
+
+	a.printSummary(cycle)
+
+	return nil
+}
+
+func (a *App) refreshTokens() error {
+	for _, oauth := range a.oauths {
+		if _, err := oauth.Token(); err != nil {
+			return fmt.Errorf("error refreshing %s token: %w", oauth.siteName, err)
+		}
+	}
+	return nil
+}
+
+func (a *App) printSummary(cycle int) {
+	for _, u := range []*Updater{a.animeUpdater, a.mangaUpdater} {
+		if u.Statistics.TotalCount == 0 {
+			continue
+		}
+		log.Printf("Cycle %d [%s]: updated %d, skipped %d, ignored %d, conflicts %d, total %d",
+			cycle, u.Prefix, u.Statistics.UpdatedCount, u.Statistics.SkippedCount,
+			u.Statistics.IgnoredCount, u.Statistics.ConflictCount, u.Statistics.TotalCount)
+	}
+}
+
+func backoffDelay(failures int, limit time.Duration) time.Duration {
+	d := watchRetryDelay
+	for i := 1; i < failures && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}